@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -92,7 +93,7 @@ func (c *Client) Remove(key string, token interface{}) string {
 
 //Keys - returns keys by pattern
 func (c *Client) Keys(pattern string, token interface{}) string {
-	return c.ProcessRequest(http.MethodGet, fmt.Sprintf("/keys/%s", pattern), token, nil)
+	return c.ProcessRequest(http.MethodGet, "/keys?match="+url.QueryEscape(pattern), token, nil)
 }
 
 //SetExpires - set expiration time for key
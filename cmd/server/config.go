@@ -3,16 +3,66 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"time"
 )
 
 //Config - application-specific configurations
 type Config struct {
-	SecretKey     string `json:"secretKey"`
-	Authorization bool   `json:"authorization"`
-	Users         []User `json:"users"`
-	DumpFile      string `json:"dumpFile"`
-	DumpInterval  int64  `json:"dumpInterval"`
-	Port          int    `json:"port"`
+	SecretKey          string `json:"secretKey"`
+	Authorization      bool   `json:"authorization"`
+	Users              []User `json:"users"`
+	DumpFile           string `json:"dumpFile"`
+	DumpInterval       int64  `json:"dumpInterval"`
+	DumpGenerations    int    `json:"dumpGenerations"`
+	DumpFormat         string `json:"dumpFormat"`
+	DumpCompression    string `json:"dumpCompression"`
+	AOFFile            string `json:"aofFile"`
+	AOFFsync           string `json:"aofFsync"`
+	AOFRewriteInterval int64  `json:"aofRewriteInterval"`
+	Recovery           string `json:"recovery"`
+	Shards             int    `json:"shards"`
+	MaxMemory          int64  `json:"maxMemory"`
+	MaxKeys            int64  `json:"maxKeys"`
+	EvictionPolicy     string `json:"evictionPolicy"`
+	ShutdownTimeout    int64  `json:"shutdownTimeout"`
+	MaxBatchSize       int    `json:"maxBatchSize"`
+	MaxScanCount       int    `json:"maxScanCount"`
+	EventBuffer        int    `json:"eventBuffer"`
+	MaxWait            int64  `json:"maxWait"`
+	Port               int    `json:"port"`
+}
+
+//DefaultMaxBatchSize - max number of keys of batch request if it is not configured
+const DefaultMaxBatchSize = 1000
+
+//BatchSize returns max number of keys of batch request
+func (c *Config) BatchSize() int {
+	if c.MaxBatchSize <= 0 {
+		return DefaultMaxBatchSize
+	}
+	return c.MaxBatchSize
+}
+
+//DefaultMaxScanCount - max number of keys returned by single scan request if it is not configured
+const DefaultMaxScanCount = 1000
+
+//ScanLimit returns max number of keys returned by single scan request
+func (c *Config) ScanLimit() int {
+	if c.MaxScanCount <= 0 {
+		return DefaultMaxScanCount
+	}
+	return c.MaxScanCount
+}
+
+//DefaultMaxWait - max time of blocking request in seconds if it is not configured
+const DefaultMaxWait = 60
+
+//WaitLimit returns max time of blocking request
+func (c *Config) WaitLimit() time.Duration {
+	if c.MaxWait <= 0 {
+		return DefaultMaxWait * time.Second
+	}
+	return time.Duration(c.MaxWait) * time.Second
 }
 
 //User - part of configuration for user auth data
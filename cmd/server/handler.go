@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/andreipimenov/kvstore/model"
+	"github.com/andreipimenov/kvstore/store"
 	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
 )
 
 //WriteResponse - common helper function: marshal data to JSON and write into response
@@ -28,6 +37,44 @@ func WriteErrorResponse(w http.ResponseWriter, code int, errs ...*model.APIMessa
 	w.Write(j)
 }
 
+//StoreError - helper function: map storage error to response status and error
+//Error of transaction is mapped by error of failed operation
+func StoreError(err error) (int, *model.APIMessage) {
+	cause := err
+	if e, ok := err.(*store.TxError); ok {
+		cause = e.Err
+	}
+	if _, ok := cause.(*store.PatternError); ok {
+		return http.StatusBadRequest, &model.APIMessage{Code: "BadRequest", Message: err.Error()}
+	}
+	switch cause {
+	case ErrInvalidValue:
+		return http.StatusBadRequest, &model.APIMessage{Code: "BadRequest", Message: "Invalid value"}
+	case store.ErrWrongType:
+		return http.StatusConflict, &model.APIMessage{Code: "WrongType", Message: err.Error()}
+	case store.ErrNoSuchKey:
+		return http.StatusNotFound, &model.APIMessage{Code: "NotFound", Message: err.Error()}
+	case store.ErrNotInteger, store.ErrNotFloat, store.ErrOverflow,
+		store.ErrIndexOutOfRange, store.ErrPivotNotFound, store.ErrListValueMissing, store.ErrHashValueMissing,
+		store.ErrSetValueMissing, store.ErrNotFiniteScore,
+		store.ErrUnknownOp, store.ErrInvalidExpires, store.ErrEmptyTx,
+		store.ErrInvalidCursor, store.ErrUnknownType, store.ErrSameKey:
+		return http.StatusBadRequest, &model.APIMessage{Code: "BadRequest", Message: err.Error()}
+	case store.ErrConditionFailed:
+		return http.StatusPreconditionFailed, &model.APIMessage{Code: "PreconditionFailed", Message: err.Error()}
+	case store.ErrOutOfMemory:
+		return http.StatusInsufficientStorage, &model.APIMessage{Code: "InsufficientStorage", Message: err.Error()}
+	default:
+		return http.StatusInternalServerError, &model.APIMessage{Code: "InternalError", Message: err.Error()}
+	}
+}
+
+//WriteStoreError - helper function: write storage error response
+func WriteStoreError(w http.ResponseWriter, err error) {
+	code, msg := StoreError(err)
+	WriteErrorResponse(w, code, msg)
+}
+
 //JSONCtx - setup all requests mime-type to application/json
 func JSONCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -113,6 +160,125 @@ func PingHandler() http.HandlerFunc {
 	})
 }
 
+var errBytesValue = errors.New("Bytes must being base64 string")
+
+//DecodeValue converts value of request into stored value according to type
+//Bytes are passed as base64 string, other values are stored as they are decoded from JSON
+func DecodeValue(value interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "", store.TypeString, store.TypeList, store.TypeHash:
+		return value, nil
+	case store.TypeBytes:
+		str, ok := value.(string)
+		if !ok {
+			return nil, errBytesValue
+		}
+		b, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, errBytesValue
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("Type %s cannot being set with value", typ)
+	}
+}
+
+//ETag returns entity tag of value version
+func ETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+//parseETag returns version of entity tag (weak tags are compared as strong ones)
+func parseETag(tag string) (uint64, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, fmt.Errorf("Invalid ETag %s", tag)
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("Invalid ETag %s", tag)
+	}
+	return version, nil
+}
+
+//ParseCondition converts If-Match and If-None-Match headers into condition of write
+//"*" requires key to exist (If-Match) or not to exist (If-None-Match), otherwise header holds ETag of version
+func ParseCondition(r *http.Request) (store.Condition, error) {
+	cond := store.Condition{}
+	if v := r.Header.Get("If-Match"); v != "" {
+		if v == "*" {
+			cond.Exists = store.IfExists
+		} else {
+			version, err := parseETag(v)
+			if err != nil {
+				return cond, err
+			}
+			cond.Version = version
+		}
+	}
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if v == "*" {
+			cond.Exists = store.IfNotExists
+		} else {
+			version, err := parseETag(v)
+			if err != nil {
+				return cond, err
+			}
+			cond.NotVersion = version
+		}
+	}
+	if r.Header.Get("If-Match") == "*" && cond.Exists == store.IfNotExists {
+		return cond, errConflictingConditions
+	}
+	return cond, nil
+}
+
+//ParseWait returns time of blocking request from wait query parameter (duration like 30s or number of seconds)
+//limited by maxWait of configuration, returns 0 if request must not block
+func ParseWait(c *Config, r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, errSeconds := strconv.ParseInt(v, 10, 64)
+		if errSeconds != nil {
+			return 0, fmt.Errorf("Invalid wait %s", v)
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0, fmt.Errorf("Invalid wait %s", v)
+	}
+	if limit := c.WaitLimit(); wait > limit {
+		wait = limit
+	}
+	return wait, nil
+}
+
+var errConflictingConditions = errors.New("Conditions of write cannot being satisfied together")
+
+//WithMode adds SET-if-not-exists (nx) or SET-if-exists (xx) mode to condition
+func WithMode(cond store.Condition, nx bool, xx bool) (store.Condition, error) {
+	mode := store.IfAny
+	switch {
+	case nx && xx:
+		return cond, errConflictingConditions
+	case nx:
+		mode = store.IfNotExists
+	case xx:
+		mode = store.IfExists
+	default:
+		return cond, nil
+	}
+	if cond.Exists != store.IfAny && cond.Exists != mode {
+		return cond, errConflictingConditions
+	}
+	cond.Exists = mode
+	return cond, nil
+}
+
 //SetHandler - set value with key (add or replace)
 func SetHandler(s *Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -130,32 +296,91 @@ func SetHandler(s *Store) http.HandlerFunc {
 			})
 			return
 		}
-		err = s.Set(req.Key, req.Value)
+		if req.Expires < 0 || req.PExpires < 0 {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Expiration time must being positive int64 number",
+			})
+			return
+		}
+		value, err := DecodeValue(req.Value, req.Type)
 		if err != nil {
 			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
-				Code: "BadRequest", Message: "Invalid value",
+				Code: "BadRequest", Message: err.Error(),
 			})
 			return
 		}
-		WriteResponse(w, http.StatusCreated, &model.APIMessage{
-			Message: "OK",
+		cond, err := ParseCondition(r)
+		if err == nil {
+			cond, err = WithMode(cond, req.NX, req.XX)
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: err.Error(),
+			})
+			return
+		}
+		expires := req.PExpires
+		if expires == 0 {
+			expires = req.Expires * 1000
+		}
+		version, err := s.SetIf(req.Key, value, expires, cond)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		w.Header().Set("ETag", ETag(version))
+		WriteResponse(w, http.StatusCreated, &model.APIKeyValue{
+			Key:     req.Key,
+			Version: version,
 		})
 	})
 }
 
-//GetHandler - get value by key
-func GetHandler(s *Store) http.HandlerFunc {
+//GetHandler - get value by key, with wait query parameter blocks until version of key is greater than version parameter
+//Responds 304 if version parameter is set and key is not changed
+func GetHandler(c *Config, s *Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := chi.URLParam(r, "key")
-		value, err := s.Get(key)
+		wait, err := ParseWait(c, r)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: err.Error(),
+			})
+			return
+		}
+		version := uint64(0)
+		v := r.URL.Query().Get("version")
+		if v != "" {
+			if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: fmt.Sprintf("Invalid version %s", v),
+				})
+				return
+			}
+		}
+		var e store.Entry
+		if wait > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			e, err = s.WaitEntry(ctx, key, version)
+			cancel()
+		} else {
+			e, err = s.GetEntry(key)
+		}
 		if err != nil {
 			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
 				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
 			})
 			return
 		}
+		w.Header().Set("ETag", ETag(e.Version))
+		if v != "" && e.Version <= version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
-			Value: value,
+			Value:   e.Value,
+			Type:    e.Type,
+			Version: e.Version,
 		})
 	})
 }
@@ -164,7 +389,18 @@ func GetHandler(s *Store) http.HandlerFunc {
 func RemoveHandler(s *Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := chi.URLParam(r, "key")
-		err := s.Remove(key)
+		cond, err := ParseCondition(r)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: err.Error(),
+			})
+			return
+		}
+		err = s.RemoveIf(key, cond)
+		if err == store.ErrConditionFailed {
+			WriteStoreError(w, err)
+			return
+		}
 		if err != nil {
 			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
 				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
@@ -177,94 +413,320 @@ func RemoveHandler(s *Store) http.HandlerFunc {
 	})
 }
 
-//KeysHandler - get keys by pattern
-func KeysHandler(s *Store) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		pattern := chi.URLParam(r, "pattern")
-		keys, _ := s.Keys(pattern)
-		WriteResponse(w, http.StatusOK, &model.APIKeys{
-			Keys: keys,
-		})
+//RenameHandler - move value of key with its type and expiration time to destination key
+//Destination is overwritten unless nx is true
+func RenameHandler(s *Store) http.HandlerFunc {
+	return transferHandler(func(key string, req *model.APIDestination) error {
+		return s.Rename(key, req.Destination, req.NX)
 	})
 }
 
-//GetIndexHandler - get value by key
-func GetIndexHandler(s *Store) http.HandlerFunc {
+//CopyHandler - copy value of key with its type and expiration time to destination key
+//Existing destination is overwritten only if replace is true
+func CopyHandler(s *Store) http.HandlerFunc {
+	return transferHandler(func(key string, req *model.APIDestination) error {
+		return s.Copy(key, req.Destination, req.Replace)
+	})
+}
+
+//transferHandler - decode destination of rename or copy and apply fn
+func transferHandler(fn func(key string, req *model.APIDestination) error) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := chi.URLParam(r, "key")
-		index := chi.URLParam(r, "index")
-		value, err := s.Get(key)
+		req := &model.APIDestination{}
+		err := json.NewDecoder(r.Body).Decode(req)
 		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		if req.Destination == "" {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Destination must being not-empty string",
+			})
+			return
+		}
+		err = fn(key, req)
+		if err == store.ErrNoSuchKey {
 			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
 				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
 			})
 			return
 		}
-		switch v := value.(type) {
-		case []interface{}:
-			i, err := strconv.Atoi(index)
-			if err != nil {
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIMessage{
+			Message: "OK",
+		})
+	})
+}
+
+//KeysHandler - get keys by pattern from path or from match query parameter (pattern may contain slashes)
+//Pattern is glob unless regex query parameter is true, all keys are returned if pattern is not set
+func KeysHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		pattern := chi.URLParam(r, "pattern")
+		if pattern == "" {
+			pattern = q.Get("match")
+		}
+		regex := false
+		if v := q.Get("regex"); v != "" {
+			var err error
+			if regex, err = strconv.ParseBool(v); err != nil {
 				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
-					Code: "BadRequest", Message: "Index type must being int for this key",
+					Code: "BadRequest", Message: "Regex must being boolean value",
 				})
 				return
 			}
-			if i < 0 || i > len(v)-1 {
+		}
+		if pattern == "" && !regex {
+			pattern = "*"
+		}
+		keys, err := s.Keys(pattern, regex)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeys{
+			Keys: keys,
+		})
+	})
+}
+
+//ScanHandler - iterate keys incrementally with cursor, optional match (glob pattern) and type filter
+//count (10 by default) is limited by max batch size
+func ScanHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		count := 0
+		if v := q.Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
 				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
-					Code: "BadRequest", Message: "Index out of range",
+					Code: "BadRequest", Message: "Count must being positive integer number",
 				})
 				return
 			}
-			WriteResponse(w, http.StatusOK, &model.APIKeyValue{
-				Value: v[i],
-			})
+			count = n
+		}
+		if count > c.ScanLimit() {
+			count = c.ScanLimit()
+		}
+		keys, cursor, err := s.Scan(q.Get("cursor"), q.Get("match"), q.Get("type"), count)
+		if err != nil {
+			WriteStoreError(w, err)
 			return
-		case map[string]interface{}:
-			if _, ok := v[index]; ok {
-				WriteResponse(w, http.StatusOK, &model.APIKeyValue{
-					Value: v[index],
+		}
+		WriteResponse(w, http.StatusOK, &model.APIScan{
+			Cursor: cursor,
+			Keys:   keys,
+		})
+	})
+}
+
+//RawSetHandler - set bytes value with key from application/octet-stream body
+//Expiration time is passed in query as expires (seconds) or pexpires (milliseconds)
+//If-Match and If-None-Match headers make write conditional like in SetHandler, new version is returned in ETag header
+func RawSetHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		var expires int64
+		for _, name := range []string{"expires", "pexpires"} {
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "Expiration time must being positive int64 number",
 				})
 				return
 			}
+			if name == "expires" {
+				n *= 1000
+			}
+			expires = n
+		}
+		cond, err := ParseCondition(r)
+		if err != nil {
 			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
-				Code: "BadRequest", Message: fmt.Sprintf("Index %s is not set", index),
+				Code: "BadRequest", Message: err.Error(),
 			})
 			return
-		default:
+		}
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
 			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
-				Code: "BadRequest", Message: "Value must being []string or map[string]string",
+				Code: "BadRequest", Message: "Cannot read request body",
 			})
 			return
 		}
+		version, err := s.SetIf(key, value, expires, cond)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		w.Header().Set("ETag", ETag(version))
+		WriteResponse(w, http.StatusCreated, &model.APIKeyValue{
+			Key:     key,
+			Version: version,
+		})
 	})
 }
 
-//SetExpiresHandler - set expiration time for key
-func SetExpiresHandler(s *Store) http.HandlerFunc {
+//RawGetHandler - get bytes or string value by key as application/octet-stream body
+//Errors are written as JSON like in other handlers
+func RawGetHandler(s *Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := chi.URLParam(r, "key")
-		_, err := s.Get(key)
+		value, _, err := s.GetWithType(key)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+			})
+			return
+		}
+		var b []byte
+		switch v := value.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		default:
+			WriteStoreError(w, store.ErrWrongType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
+
+//TypeHandler - get type of value by key
+func TypeHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		typ, err := s.Type(key)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Key:  key,
+			Type: typ,
+		})
+	})
+}
+
+//GetIndexHandler - get item of list by index or field of hash
+//Keys of other types are rejected with WrongType error
+func GetIndexHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		index := chi.URLParam(r, "index")
+		typ, err := s.Type(key)
 		if err != nil {
 			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
 				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
 			})
 			return
 		}
+		var value interface{}
+		switch typ {
+		case store.TypeList:
+			i, err := strconv.Atoi(index)
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "Index must being int for list",
+				})
+				return
+			}
+			value, err = s.LIndex(key, i)
+			if err != nil {
+				WriteStoreError(w, err)
+				return
+			}
+		case store.TypeHash:
+			value, err = s.HGet(key, index)
+			if err == store.ErrWrongType {
+				WriteStoreError(w, err)
+				return
+			}
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: fmt.Sprintf("Index %s is not set", index),
+				})
+				return
+			}
+		default:
+			WriteStoreError(w, store.ErrWrongType)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: value,
+		})
+	})
+}
+
+//ParseExpireAt converts RFC3339 string or unix milliseconds number into time
+func ParseExpireAt(v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, x)
+	case float64:
+		ms := int64(x)
+		return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), nil
+	default:
+		return time.Time{}, fmt.Errorf("expireAt must being RFC3339 string or unix time in milliseconds")
+	}
+}
+
+//SetExpiresHandler - set expiration time for key (relative in seconds or milliseconds, or absolute)
+func SetExpiresHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
 		req := &model.APIKeyExpires{}
-		err = json.NewDecoder(r.Body).Decode(req)
+		err := json.NewDecoder(r.Body).Decode(req)
 		if err != nil {
 			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
 				Code: "BadRequest", Message: "Cannot decode request body",
 			})
 			return
 		}
-		if req.Expires <= 0 {
+		switch {
+		case req.ExpireAt != nil:
+			at, err := ParseExpireAt(req.ExpireAt)
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "expireAt must being RFC3339 string or unix time in milliseconds",
+				})
+				return
+			}
+			err = s.SetExpiresAt(key, at)
+		case req.PExpires > 0:
+			err = s.SetPExpires(key, req.PExpires)
+		case req.Expires > 0:
+			err = s.SetExpires(key, req.Expires)
+		default:
 			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
 				Code: "BadRequest", Message: "Expiration time must being positive int64 number",
 			})
 			return
 		}
-		s.SetExpires(key, req.Expires)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+			})
+			return
+		}
 		WriteResponse(w, http.StatusOK, &model.APIMessage{
 			Message: "OK",
 		})
@@ -282,7 +744,7 @@ func GetExpiresHandler(s *Store) http.HandlerFunc {
 			})
 			return
 		}
-		expires, err := s.GetExpires(key)
+		pexpires, err := s.GetPExpires(key)
 		if err != nil {
 			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
 				Code: "NotFound", Message: fmt.Sprintf("Error: %s", err.Error()),
@@ -290,7 +752,1161 @@ func GetExpiresHandler(s *Store) http.HandlerFunc {
 			return
 		}
 		WriteResponse(w, http.StatusOK, &model.APIKeyExpires{
-			Expires: expires,
+			Expires:  (pexpires + 500) / 1000,
+			PExpires: pexpires,
+			ExpireAt: time.Now().Add(time.Duration(pexpires) * time.Millisecond).UTC().Format(time.RFC3339Nano),
+		})
+	})
+}
+
+//PersistHandler - remove expiration time for key
+func PersistHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		_, err := s.Get(key)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+			})
+			return
+		}
+		err = s.Persist(key)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Error: %s", err.Error()),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIMessage{
+			Message: "OK",
 		})
 	})
 }
+
+//IncrHandler - increment counter by delta (1 by default), creates counter if key does not exist
+func IncrHandler(s *Store) http.HandlerFunc {
+	return incrHandler(s, 1)
+}
+
+//DecrHandler - decrement counter by delta (1 by default), creates counter if key does not exist
+func DecrHandler(s *Store) http.HandlerFunc {
+	return incrHandler(s, -1)
+}
+
+//incrHandler - common implementation of increment and decrement, sign is multiplier of delta
+func incrHandler(s *Store, sign int64) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		req := &model.APIIncr{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil && err != io.EOF {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		if req.Expires < 0 || req.PExpires < 0 {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Expiration time must being positive int64 number",
+			})
+			return
+		}
+		expires := req.PExpires
+		if expires == 0 {
+			expires = req.Expires * 1000
+		}
+		var value interface{}
+		switch delta := req.Delta.String(); {
+		case delta == "":
+			value, err = s.IncrBy(key, sign, expires)
+		case strings.ContainsAny(delta, ".eE"):
+			f, parseErr := req.Delta.Float64()
+			if parseErr != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "Delta must being number",
+				})
+				return
+			}
+			value, err = s.IncrByFloat(key, float64(sign)*f, expires)
+		default:
+			i, parseErr := req.Delta.Int64()
+			if parseErr != nil || i == math.MinInt64 {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "Delta must being int64 number",
+				})
+				return
+			}
+			value, err = s.IncrBy(key, sign*i, expires)
+		}
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Key:   key,
+			Value: value,
+		})
+	})
+}
+
+//decodeList - helper function: decode list request, writes error response and returns false if body is invalid
+func decodeList(w http.ResponseWriter, r *http.Request) (*model.APIList, bool) {
+	req := &model.APIList{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Cannot decode request body",
+		})
+		return nil, false
+	}
+	return req, true
+}
+
+//PushHandler - add values to the head (left) or to the tail of list, responses new length
+func PushHandler(s *Store, left bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeList(w, r)
+		if !ok {
+			return
+		}
+		n, err := s.Push(chi.URLParam(r, "key"), req.Values, left)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//PopHandler - remove and get first (left) or last item of list, with wait query parameter blocks until item is pushed
+func PopHandler(c *Config, s *Store, left bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		wait, err := ParseWait(c, r)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: err.Error(),
+			})
+			return
+		}
+		var value interface{}
+		if wait > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			value, err = s.BPop(ctx, key, left)
+			cancel()
+		} else {
+			value, err = s.Pop(key, left)
+		}
+		if err == store.ErrWrongType {
+			WriteStoreError(w, err)
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: value,
+		})
+	})
+}
+
+//RangeHandler - get items of list from start to stop inclusive (query parameters, whole list by default)
+func RangeHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bounds := []int{0, -1}
+		for i, name := range []string{"start", "stop"} {
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: fmt.Sprintf("%s must being int", name),
+				})
+				return
+			}
+			bounds[i] = n
+		}
+		values, err := s.LRange(chi.URLParam(r, "key"), bounds[0], bounds[1])
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: values,
+		})
+	})
+}
+
+//TrimHandler - keep only items of list from start to stop inclusive
+func TrimHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeList(w, r)
+		if !ok {
+			return
+		}
+		if err := s.LTrim(chi.URLParam(r, "key"), req.Start, req.Stop); err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIMessage{
+			Message: "OK",
+		})
+	})
+}
+
+//ListSetHandler - replace item of list at index
+func ListSetHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeList(w, r)
+		if !ok {
+			return
+		}
+		if err := s.LSet(chi.URLParam(r, "key"), req.Index, req.Value); err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIMessage{
+			Message: "OK",
+		})
+	})
+}
+
+//ListRemoveHandler - remove items equal to value from list, responses number of removed items
+func ListRemoveHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeList(w, r)
+		if !ok {
+			return
+		}
+		n, err := s.LRem(chi.URLParam(r, "key"), req.Count, req.Value)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//ListInsertHandler - insert value before or after pivot, responses new length
+func ListInsertHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeList(w, r)
+		if !ok {
+			return
+		}
+		n, err := s.LInsert(chi.URLParam(r, "key"), req.Before, req.Pivot, req.Value)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//ListLenHandler - get length of list
+func ListLenHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := s.LLen(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//HashGetAllHandler - get all fields and values of hash
+//With keys query parameter responses only names of fields, with count - number of fields
+func HashGetAllHandler(s *Store) http.HandlerFunc {
+	keys, count := HashKeysHandler(s), HashLenHandler(s)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if _, ok := q["keys"]; ok {
+			keys(w, r)
+			return
+		}
+		if _, ok := q["count"]; ok {
+			count(w, r)
+			return
+		}
+		h, err := s.HGetAll(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: h,
+		})
+	})
+}
+
+//HashSetHandler - set multiple fields of hash at once, responses number of added fields
+func HashSetHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APIHash{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		n, err := s.HSet(chi.URLParam(r, "key"), req.Fields)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//HashKeysHandler - get names of fields of hash
+func HashKeysHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := s.HKeys(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeys{
+			Keys: keys,
+		})
+	})
+}
+
+//HashLenHandler - get number of fields of hash
+func HashLenHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := s.HLen(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//FieldGetHandler - get value of field of hash
+func FieldGetHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, field := chi.URLParam(r, "key"), chi.URLParam(r, "field")
+		value, err := s.HGet(key, field)
+		if err == store.ErrWrongType {
+			WriteStoreError(w, err)
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Field %s of key %s not found", field, key),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: value,
+		})
+	})
+}
+
+//FieldExistsHandler - check if hash has field (status 200 or 404 without body)
+func FieldExistsHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := s.HExists(chi.URLParam(r, "key"), chi.URLParam(r, "field"))
+		switch {
+		case err != nil:
+			w.WriteHeader(http.StatusConflict)
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+//FieldSetHandler - set value of field of hash, responses 1 if field is added and 0 if it is updated
+func FieldSetHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APIHash{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		n, err := s.HSet(chi.URLParam(r, "key"), map[string]string{chi.URLParam(r, "field"): req.Value})
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//FieldRemoveHandler - remove field of hash (empty hash is removed)
+func FieldRemoveHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, field := chi.URLParam(r, "key"), chi.URLParam(r, "field")
+		err := s.HDel(key, field)
+		if err == store.ErrWrongType {
+			WriteStoreError(w, err)
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Field %s of key %s not found", field, key),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIMessage{
+			Message: "OK",
+		})
+	})
+}
+
+//FieldIncrHandler - increment integer value of field by delta (1 by default)
+func FieldIncrHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		req := &model.APIIncr{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		delta := int64(1)
+		if req.Delta != "" {
+			var err error
+			if delta, err = req.Delta.Int64(); err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: "Delta must being int64 number",
+				})
+				return
+			}
+		}
+		value, err := s.HIncrBy(key, chi.URLParam(r, "field"), delta)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Key:   key,
+			Value: value,
+		})
+	})
+}
+
+//SetMembersHandler - get sorted members of set
+func SetMembersHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		members, err := s.SMembers(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: members,
+			Type:  store.TypeSet,
+		})
+	})
+}
+
+//SetAddHandler - add members to set (remove if remove is true), responses number of added (removed) members
+func SetAddHandler(s *Store, remove bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APISet{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		key := chi.URLParam(r, "key")
+		var n int
+		var err error
+		if remove {
+			n, err = s.SRem(key, req.Members)
+		} else {
+			n, err = s.SAdd(key, req.Members)
+		}
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//SetLenHandler - get number of members of set
+func SetLenHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := s.SCard(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//SetIsMemberHandler - check if member is in set (status 200 or 404 without body)
+func SetIsMemberHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := s.SIsMember(chi.URLParam(r, "key"), chi.URLParam(r, "member"))
+		switch {
+		case err != nil:
+			w.WriteHeader(http.StatusConflict)
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+//SetCombineHandler - intersection, union or difference of sets given by key query parameters
+func SetCombineHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := r.URL.Query()["key"]
+		if len(keys) == 0 {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "At least one key is required",
+			})
+			return
+		}
+		members, err := s.SCombine(chi.URLParam(r, "op"), keys)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: members,
+			Type:  store.TypeSet,
+		})
+	})
+}
+
+//decodeZSet - helper function: decode sorted set request, writes error response and returns false if body is invalid
+func decodeZSet(w http.ResponseWriter, r *http.Request) (*model.APIZSet, bool) {
+	req := &model.APIZSet{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Cannot decode request body",
+		})
+		return nil, false
+	}
+	return req, true
+}
+
+//ZSetRangeHandler - get members of sorted set by rank (start and stop query parameters)
+//or by score (min and max query parameters, -inf and +inf are allowed)
+func ZSetRangeHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "key")
+		q := r.URL.Query()
+		var members store.ZSet
+		var err error
+		if q.Get("min") != "" || q.Get("max") != "" {
+			bounds := []float64{math.Inf(-1), math.Inf(1)}
+			for i, name := range []string{"min", "max"} {
+				if v := q.Get(name); v != "" {
+					if bounds[i], err = strconv.ParseFloat(v, 64); err != nil {
+						WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+							Code: "BadRequest", Message: fmt.Sprintf("%s must being number", name),
+						})
+						return
+					}
+				}
+			}
+			members, err = s.ZRangeByScore(key, bounds[0], bounds[1])
+		} else {
+			bounds := []int{0, -1}
+			for i, name := range []string{"start", "stop"} {
+				if v := q.Get(name); v != "" {
+					if bounds[i], err = strconv.Atoi(v); err != nil {
+						WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+							Code: "BadRequest", Message: fmt.Sprintf("%s must being int", name),
+						})
+						return
+					}
+				}
+			}
+			members, err = s.ZRange(key, bounds[0], bounds[1])
+		}
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: members,
+			Type:  store.TypeZSet,
+		})
+	})
+}
+
+//ZSetAddHandler - set scores of members of sorted set, responses number of added members
+func ZSetAddHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeZSet(w, r)
+		if !ok {
+			return
+		}
+		members := make([]store.ZMember, len(req.Members))
+		for i, m := range req.Members {
+			members[i] = store.ZMember{Member: m.Member, Score: m.Score}
+		}
+		n, err := s.ZAdd(chi.URLParam(r, "key"), members)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//ZSetRemoveHandler - remove members from sorted set, responses number of removed members
+func ZSetRemoveHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APISet{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		n, err := s.ZRem(chi.URLParam(r, "key"), req.Members)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//ZSetIncrHandler - add delta to score of member, responses new score
+func ZSetIncrHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeZSet(w, r)
+		if !ok {
+			return
+		}
+		key := chi.URLParam(r, "key")
+		score, err := s.ZIncrBy(key, req.Member, req.Delta)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Key:   key,
+			Value: score,
+		})
+	})
+}
+
+//ZSetRankHandler - get 0-based rank of member ordered by score
+func ZSetRankHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, member := chi.URLParam(r, "key"), chi.URLParam(r, "member")
+		rank, err := s.ZRank(key, member)
+		if err == store.ErrWrongType {
+			WriteStoreError(w, err)
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusNotFound, &model.APIMessage{
+				Code: "NotFound", Message: fmt.Sprintf("Member %s of key %s not found", member, key),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIKeyValue{
+			Value: rank,
+		})
+	})
+}
+
+//ZSetLenHandler - get number of members of sorted set
+func ZSetLenHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := s.ZCard(chi.URLParam(r, "key"))
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APICount{
+			Count: n,
+		})
+	})
+}
+
+//StatsHandler - storage counters (number of keys, dump saves and failures)
+func StatsHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteResponse(w, http.StatusOK, s.Stats())
+	})
+}
+
+//SnapshotHandler - save storage dump on demand
+//Responses 409 if dump file is not configured
+func SnapshotHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		size, err := s.Save()
+		if err == store.ErrNoDumpFile {
+			WriteErrorResponse(w, http.StatusConflict, &model.APIMessage{
+				Code: "NoDumpFile", Message: "Cannot save snapshot: dump file is not configured",
+			})
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusInternalServerError, &model.APIMessage{
+				Code: "InternalError", Message: fmt.Sprintf("Cannot save snapshot: %s", err.Error()),
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APISnapshot{
+			Size:     size,
+			Duration: int64(time.Since(start) / time.Millisecond),
+		})
+	})
+}
+
+//TxHandler - apply ordered operations (set, remove, incr, expire) all-or-nothing
+func TxHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APITx{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		ops := make([]store.Op, len(req.Ops))
+		for i, op := range req.Ops {
+			if op.Key == "" {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: fmt.Sprintf("Key of operation %d must being not-empty string", i),
+				})
+				return
+			}
+			value, err := DecodeValue(op.Value, op.Type)
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+					Code: "BadRequest", Message: fmt.Sprintf("Operation %d: %s", i, err.Error()),
+				})
+				return
+			}
+			expires := op.PExpires
+			if expires == 0 {
+				expires = op.Expires * 1000
+			}
+			ops[i] = store.Op{
+				Op:      op.Op,
+				Key:     op.Key,
+				Value:   value,
+				Delta:   op.Delta,
+				Expires: expires,
+				Version: op.Version,
+			}
+		}
+		results, err := s.Exec(ops)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		resp := &model.APITxResults{Results: make([]model.APITxResult, len(results))}
+		for i, result := range results {
+			resp.Results[i] = model.APITxResult{
+				Op:      ops[i].Op,
+				Key:     ops[i].Key,
+				OK:      result.OK,
+				Value:   result.Value,
+				Version: result.Version,
+			}
+		}
+		WriteResponse(w, http.StatusOK, resp)
+	})
+}
+
+//checkBatch - helper function: validate number of keys of batch request, writes error response if it is invalid
+func checkBatch(w http.ResponseWriter, c *Config, n int) bool {
+	if n == 0 {
+		WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Batch must contain at least one key",
+		})
+		return false
+	}
+	if n > c.BatchSize() {
+		WriteErrorResponse(w, http.StatusRequestEntityTooLarge, &model.APIMessage{
+			Code: "TooLarge", Message: fmt.Sprintf("Batch must contain at most %d keys", c.BatchSize()),
+		})
+		return false
+	}
+	return true
+}
+
+//decodeBatchKeys - helper function: decode and validate keys of batch request, writes error response if it is invalid
+func decodeBatchKeys(w http.ResponseWriter, r *http.Request, c *Config) (*model.APIBatchKeys, bool) {
+	req := &model.APIBatchKeys{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Cannot decode request body",
+		})
+		return nil, false
+	}
+	return req, checkBatch(w, c, len(req.Keys))
+}
+
+//batchError - helper function: result of batch operation for key with error
+func batchError(key string, code int, msg *model.APIMessage) model.APIBatchResult {
+	return model.APIBatchResult{Key: key, Status: code, Error: msg}
+}
+
+//BatchGetHandler - get values of many keys, missing keys are reported with status 404
+func BatchGetHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeBatchKeys(w, r, c)
+		if !ok {
+			return
+		}
+		resp := &model.APIBatchResults{Results: make([]model.APIBatchResult, len(req.Keys))}
+		for i, key := range req.Keys {
+			e, err := s.GetEntry(key)
+			if err != nil {
+				resp.Results[i] = batchError(key, http.StatusNotFound, &model.APIMessage{
+					Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+				})
+				continue
+			}
+			resp.Results[i] = model.APIBatchResult{
+				Key:     key,
+				Status:  http.StatusOK,
+				Value:   e.Value,
+				Type:    e.Type,
+				Version: e.Version,
+			}
+		}
+		WriteResponse(w, http.StatusOK, resp)
+	})
+}
+
+//BatchSetHandler - set values of many keys, every key is set independently
+func BatchSetHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &model.APIBatchSet{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		if !checkBatch(w, c, len(req.Items)) {
+			return
+		}
+		resp := &model.APIBatchResults{Results: make([]model.APIBatchResult, len(req.Items))}
+		for i, item := range req.Items {
+			resp.Results[i] = batchSet(s, item)
+		}
+		WriteResponse(w, http.StatusOK, resp)
+	})
+}
+
+//batchSet - helper function: set value of single item of batch
+func batchSet(s *Store, item model.APIKeyValue) model.APIBatchResult {
+	if item.Key == "" {
+		return batchError(item.Key, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Key must being not-empty string",
+		})
+	}
+	if item.Expires < 0 || item.PExpires < 0 {
+		return batchError(item.Key, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: "Expiration time must being positive int64 number",
+		})
+	}
+	value, err := DecodeValue(item.Value, item.Type)
+	if err != nil {
+		return batchError(item.Key, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: err.Error(),
+		})
+	}
+	cond, err := WithMode(store.Condition{}, item.NX, item.XX)
+	if err != nil {
+		return batchError(item.Key, http.StatusBadRequest, &model.APIMessage{
+			Code: "BadRequest", Message: err.Error(),
+		})
+	}
+	expires := item.PExpires
+	if expires == 0 {
+		expires = item.Expires * 1000
+	}
+	version, err := s.SetIf(item.Key, value, expires, cond)
+	if err != nil {
+		code, msg := StoreError(err)
+		return batchError(item.Key, code, msg)
+	}
+	return model.APIBatchResult{Key: item.Key, Status: http.StatusCreated, Version: version}
+}
+
+//BatchRemoveHandler - remove many keys, missing keys are reported with status 404
+func BatchRemoveHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeBatchKeys(w, r, c)
+		if !ok {
+			return
+		}
+		resp := &model.APIBatchResults{Results: make([]model.APIBatchResult, len(req.Keys))}
+		for i, key := range req.Keys {
+			if err := s.Remove(key); err != nil {
+				resp.Results[i] = batchError(key, http.StatusNotFound, &model.APIMessage{
+					Code: "NotFound", Message: fmt.Sprintf("Key %s not found", key),
+				})
+				continue
+			}
+			resp.Results[i] = model.APIBatchResult{Key: key, Status: http.StatusOK}
+		}
+		WriteResponse(w, http.StatusOK, resp)
+	})
+}
+
+//Streaming of events and messages: idle streams are pinged, so disconnected subscribers are noticed
+const (
+	streamPingInterval = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+//Notices sent to subscriber before its stream is closed because it does not read events or messages fast enough
+var (
+	droppedEvent = &model.APIEvent{
+		Event: "dropped", Message: "Subscriber is too slow, events are dropped and stream is closed",
+	}
+	droppedMessage = &model.APIEvent{
+		Event: "dropped", Message: "Subscriber is too slow, messages are dropped and stream is closed",
+	}
+)
+
+var upgrader = websocket.Upgrader{}
+
+//stream - connection writing JSON messages to subscriber over WebSocket or as Server-Sent Events
+type stream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	conn    *websocket.Conn
+	closed  <-chan struct{}
+	ticker  *time.Ticker
+}
+
+//openStream starts stream over WebSocket if request is WebSocket upgrade and of Server-Sent Events otherwise
+//Returns false if stream cannot be started, error is already written to client
+func openStream(w http.ResponseWriter, r *http.Request) (*stream, bool) {
+	st := &stream{w: w}
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return nil, false
+		}
+		//messages of client are discarded, reading is required to process control messages and notice disconnect
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		st.conn, st.closed = conn, closed
+	} else {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteErrorResponse(w, http.StatusInternalServerError, &model.APIMessage{
+				Code: "InternalError", Message: "Streaming is not supported",
+			})
+			return nil, false
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		st.flusher, st.closed = flusher, r.Context().Done()
+	}
+	st.ticker = time.NewTicker(streamPingInterval)
+	return st, true
+}
+
+//done returns channel which is closed when client disconnects
+func (st *stream) done() <-chan struct{} {
+	return st.closed
+}
+
+//pings returns channel of times when idle stream must be pinged
+func (st *stream) pings() <-chan time.Time {
+	return st.ticker.C
+}
+
+//write sends v as JSON text message or as data of Server-Sent Event
+func (st *stream) write(v interface{}) error {
+	if st.conn != nil {
+		st.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return st.conn.WriteJSON(v)
+	}
+	b, _ := json.Marshal(v)
+	if _, err := fmt.Fprintf(st.w, "data: %s\n\n", b); err != nil {
+		return err
+	}
+	st.flusher.Flush()
+	return nil
+}
+
+//ping sends WebSocket ping message or comment of Server-Sent Events
+func (st *stream) ping() error {
+	if st.conn != nil {
+		return st.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+	}
+	if _, err := io.WriteString(st.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	st.flusher.Flush()
+	return nil
+}
+
+//end finishes stream after subscription is closed, notice is sent if subscriber is dropped
+//WebSocket is closed with "try again later" status if subscriber is dropped and normally otherwise
+func (st *stream) end(dropped bool, notice interface{}) {
+	if dropped {
+		st.write(notice)
+	}
+	if st.conn != nil {
+		code := websocket.CloseNormalClosure
+		if dropped {
+			code = websocket.CloseTryAgainLater
+		}
+		st.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		st.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""))
+	}
+}
+
+//close releases stream, WebSocket connection is closed
+func (st *stream) close() {
+	st.ticker.Stop()
+	if st.conn != nil {
+		st.conn.Close()
+	}
+}
+
+//EventsHandler - stream events of keys matching glob pattern from match query parameter (all keys if it is not set)
+//Events are streamed over WebSocket if request is WebSocket upgrade and as Server-Sent Events otherwise
+func EventsHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := s.Subscribe(r.URL.Query().Get("match"), c.EventBuffer)
+		if err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		defer sub.Close()
+		st, ok := openStream(w, r)
+		if !ok {
+			return
+		}
+		defer st.close()
+		for {
+			select {
+			case <-st.done():
+				return
+			case <-st.pings():
+				if err := st.ping(); err != nil {
+					return
+				}
+			case e, ok := <-sub.Events():
+				if !ok {
+					st.end(sub.Dropped(), droppedEvent)
+					return
+				}
+				if err := st.write(&model.APIEvent{Event: e.Kind, Key: e.Key, Version: e.Version}); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+//PublishHandler - publish message into channel, responds with number of subscribers which received it
+func PublishHandler(s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel := chi.URLParam(r, "name")
+		req := &model.APIPublish{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: "Cannot decode request body",
+			})
+			return
+		}
+		WriteResponse(w, http.StatusOK, &model.APIReceivers{
+			Receivers: s.Broker.Publish(channel, req.Message),
+		})
+	})
+}
+
+//SubscribeHandler - stream messages of channels and of channels matching glob patterns from channel and pattern query parameters
+//Both parameters may be repeated. Messages are streamed over WebSocket if request is WebSocket upgrade and as Server-Sent Events otherwise
+func SubscribeHandler(c *Config, s *Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		sub, err := s.Broker.Subscribe(q["channel"], q["pattern"], c.EventBuffer)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, &model.APIMessage{
+				Code: "BadRequest", Message: err.Error(),
+			})
+			return
+		}
+		defer sub.Close()
+		st, ok := openStream(w, r)
+		if !ok {
+			return
+		}
+		defer st.close()
+		for {
+			select {
+			case <-st.done():
+				return
+			case <-st.pings():
+				if err := st.ping(); err != nil {
+					return
+				}
+			case m, ok := <-sub.Messages():
+				if !ok {
+					st.end(sub.Dropped(), droppedMessage)
+					return
+				}
+				if err := st.write(&model.APIChannelMessage{Channel: m.Channel, Message: m.Payload}); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
@@ -1,11 +1,51 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/andreipimenov/kvstore/model"
+	"github.com/andreipimenov/kvstore/store"
+	"github.com/gorilla/websocket"
 )
 
+//newTestRouter creates router with empty in-memory store
+func newTestRouter(t *testing.T, c *Config) http.Handler {
+	driver, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(c, NewStore(driver))
+}
+
+//doRequest sends request to router and returns recorded response
+func doRequest(t *testing.T, router http.Handler, method string, uri string, body string) *httptest.ResponseRecorder {
+	var b io.Reader = http.NoBody
+	if body != "" {
+		b = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "http://127.0.0.1:8080/api/v1"+uri, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
 func TestPingHandler(t *testing.T) {
 	req, err := http.NewRequest("GET", "http://127.0.0.1:8080/api/v1/ping", nil)
 	if err != nil {
@@ -26,3 +66,876 @@ func TestPingHandler(t *testing.T) {
 		t.Errorf("Wrong body: got %v, expected %v", rr.Body.String(), expected)
 	}
 }
+
+func TestExpiresHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"lock","value":"owner"}`)
+
+	tests := []struct {
+		Body         string
+		ExpectedCode int
+		MaxPExpires  int64
+	}{
+		{`{"pexpires":1500}`, http.StatusOK, 1500},
+		{`{"expires":10}`, http.StatusOK, 10000},
+		{`{"expireAt":"` + time.Now().Add(time.Minute).Format(time.RFC3339) + `"}`, http.StatusOK, 60000},
+		{`{"expireAt":` + strconv.FormatInt(time.Now().Add(time.Hour).UnixNano()/1e6, 10) + `}`, http.StatusOK, 3600000},
+		{`{"expireAt":"tomorrow"}`, http.StatusBadRequest, 0},
+		{`{"expires":-1}`, http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		rr := doRequest(t, router, "POST", "/keys/lock/expires", test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s: got %v, expected %v", test.Body, rr.Code, test.ExpectedCode)
+		}
+		if test.ExpectedCode != http.StatusOK {
+			continue
+		}
+		resp := &model.APIKeyExpires{}
+		json.NewDecoder(doRequest(t, router, "GET", "/keys/lock/expires", "").Body).Decode(resp)
+		if resp.PExpires <= test.MaxPExpires-1000 || resp.PExpires > test.MaxPExpires {
+			t.Errorf("Wrong expiration time for %s: got %d, expected about %d", test.Body, resp.PExpires, test.MaxPExpires)
+		}
+	}
+
+	if rr := doRequest(t, router, "POST", "/keys/missing/expires", `{"pexpires":100}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetWithExpiresHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	if rr := doRequest(t, router, "POST", "/keys", `{"key":"session","value":"data","pexpires":5000}`); rr.Code != http.StatusCreated {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusCreated)
+	}
+	if rr := doRequest(t, router, "GET", "/keys/session/expires", ""); rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if rr := doRequest(t, router, "DELETE", "/keys/session/expires", ""); rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if rr := doRequest(t, router, "GET", "/keys/session/expires", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doRequest(t, router, "DELETE", "/keys/session/expires", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	if rr := doRequest(t, router, "POST", "/admin/snapshot", ""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "NoDumpFile") {
+		t.Errorf("Wrong response without dump file: got %v %s, expected %v", rr.Code, rr.Body.String(), http.StatusConflict)
+	}
+
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver, err := store.New(store.Options{DumpFile: filepath.Join(dir, "dump")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router = NewRouter(&Config{Port: 8080}, NewStore(driver))
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+	rr := doRequest(t, router, "POST", "/admin/snapshot", "")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	resp := &model.APISnapshot{}
+	json.NewDecoder(rr.Body).Decode(resp)
+	if fi, err := os.Stat(filepath.Join(dir, "dump")); err != nil || fi.Size() != resp.Size {
+		t.Errorf("Wrong snapshot size: got %d, expected size of dump file", resp.Size)
+	}
+}
+
+func TestSetHandlerNoEviction(t *testing.T) {
+	driver, err := store.New(store.Options{MaxKeys: 1, EvictionPolicy: store.PolicyNoEviction})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(&Config{Port: 8080}, NewStore(driver))
+	tests := []struct {
+		Body         string
+		ExpectedCode int
+	}{
+		{`{"key":"first","value":"1"}`, http.StatusCreated},
+		{`{"key":"first","value":"2"}`, http.StatusCreated},
+		{`{"key":"second","value":"1"}`, http.StatusInsufficientStorage},
+		{`{"key":"second","value":1}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if rr := doRequest(t, router, "POST", "/keys", test.Body); rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s: got %v, expected %v", test.Body, rr.Code, test.ExpectedCode)
+		}
+	}
+}
+
+func TestTypeHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+	doRequest(t, router, "POST", "/keys", `{"key":"hobbies","value":["web","sport"]}`)
+	doRequest(t, router, "POST", "/keys", `{"key":"langs","value":{"programming":"Golang"}}`)
+	doRequest(t, router, "POST", "/keys/visits/incr", "")
+	doRequest(t, router, "POST", "/keys/tags/set/add", `{"members":["go"]}`)
+
+	tests := []struct {
+		Method       string
+		URI          string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"GET", "/keys/name/type", http.StatusOK, `{"key":"name","type":"string"}`},
+		{"GET", "/keys/hobbies/type", http.StatusOK, `{"key":"hobbies","type":"list"}`},
+		{"GET", "/keys/visits/type", http.StatusOK, `{"key":"visits","type":"counter"}`},
+		{"GET", "/keys/tags/type", http.StatusOK, `{"key":"tags","type":"set"}`},
+		{"GET", "/keys/missing/type", http.StatusNotFound, ""},
+		{"GET", "/keys/visits/values", http.StatusOK, `{"value":"1","type":"counter","version":1}`},
+		{"GET", "/keys/hobbies/values/1", http.StatusOK, `{"value":"sport"}`},
+		{"GET", "/keys/hobbies/values/-1", http.StatusOK, `{"value":"sport"}`},
+		{"GET", "/keys/hobbies/values/2", http.StatusBadRequest, ""},
+		{"GET", "/keys/hobbies/values/x", http.StatusBadRequest, ""},
+		{"GET", "/keys/langs/values/programming", http.StatusOK, `{"value":"Golang"}`},
+		{"GET", "/keys/langs/values/missing", http.StatusBadRequest, ""},
+		{"GET", "/keys/name/values/0", http.StatusConflict, ""},
+		{"GET", "/keys/tags/values/0", http.StatusConflict, ""},
+		{"GET", "/keys/missing/values/0", http.StatusNotFound, ""},
+		{"POST", "/keys/name/list/lpush", http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		body := ""
+		if test.Method == "POST" {
+			body = `{"values":["x"]}`
+		}
+		rr := doRequest(t, router, test.Method, test.URI, body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s: got %v, expected %v", test.Method, test.URI, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s: got %v, expected %v", test.Method, test.URI, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
+
+func TestRawHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+
+	rr := doRequest(t, router, "PUT", "/keys/blob/raw?expires=60", "\x00\x01\xff")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Wrong status code of raw set: got %v, expected %v", rr.Code, http.StatusCreated)
+	}
+	rr = doRequest(t, router, "GET", "/keys/blob/raw", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "\x00\x01\xff" {
+		t.Errorf("Wrong raw value: got %v %q", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("Wrong content type of raw value: got %s", ct)
+	}
+	if rr := doRequest(t, router, "GET", "/keys/blob/expires", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expiration time of raw value must being set, got status %v", rr.Code)
+	}
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"GET", "/keys/blob/values", "", http.StatusOK, `{"value":"AAH/","type":"bytes","version":1}`},
+		{"POST", "/keys", `{"key":"b64","value":"aGVsbG8=","type":"bytes"}`, http.StatusCreated, ""},
+		{"GET", "/keys/b64/raw", "", http.StatusOK, "hello"},
+		{"GET", "/keys/b64/type", "", http.StatusOK, `{"key":"b64","type":"bytes"}`},
+		{"POST", "/keys", `{"key":"b64","value":"not base64","type":"bytes"}`, http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"b64","value":["a"],"type":"bytes"}`, http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"b64","value":"a","type":"zset"}`, http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"name","value":"John Doe"}`, http.StatusCreated, ""},
+		{"GET", "/keys/name/raw", "", http.StatusOK, "John Doe"},
+		{"POST", "/keys", `{"key":"list","value":["a"]}`, http.StatusCreated, ""},
+		{"GET", "/keys/list/raw", "", http.StatusConflict, ""},
+		{"GET", "/keys/missing/raw", "", http.StatusNotFound, ""},
+		{"PUT", "/keys/blob/raw?pexpires=x", "data", http.StatusBadRequest, ""},
+		{"POST", "/keys/blob/incr", "", http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
+
+func TestConditionalHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		Header       string
+		Value        string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"POST", "/keys", `{"key":"name","value":"John Doe","nx":true}`, "", "", http.StatusCreated, `{"key":"name","version":1}`},
+		{"POST", "/keys", `{"key":"name","value":"Jane Doe","nx":true}`, "", "", http.StatusPreconditionFailed, ""},
+		{"POST", "/keys", `{"key":"missing","value":"x","xx":true}`, "", "", http.StatusPreconditionFailed, ""},
+		{"POST", "/keys", `{"key":"name","value":"x","nx":true,"xx":true}`, "", "", http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"name","value":"Jane Doe"}`, "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"POST", "/keys", `{"key":"name","value":"Jane Doe"}`, "If-Match", `"1"`, http.StatusCreated, `{"key":"name","version":2}`},
+		{"POST", "/keys", `{"key":"name","value":"Jane Doe"}`, "If-Match", `1`, http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"name","value":"x"}`, "If-None-Match", `*`, http.StatusPreconditionFailed, ""},
+		{"POST", "/keys", `{"key":"name","value":"x","xx":true}`, "If-None-Match", `*`, http.StatusBadRequest, ""},
+		{"POST", "/keys", `{"key":"other","value":"x"}`, "If-Match", `*`, http.StatusPreconditionFailed, ""},
+		{"GET", "/keys/name/values", "", "", "", http.StatusOK, `{"value":"Jane Doe","type":"string","version":2}`},
+		{"DELETE", "/keys/name", "", "If-Match", `"1"`, http.StatusPreconditionFailed, ""},
+		{"DELETE", "/keys/name", "", "If-None-Match", `W/"2"`, http.StatusPreconditionFailed, ""},
+		{"DELETE", "/keys/name", "", "If-Match", `"2"`, http.StatusOK, ""},
+		{"DELETE", "/keys/name", "", "", "", http.StatusNotFound, ""},
+		{"PUT", "/keys/name/raw", "data", "If-Match", `*`, http.StatusPreconditionFailed, ""},
+		{"PUT", "/keys/name/raw", "data", "If-None-Match", `*`, http.StatusCreated, `{"key":"name","version":3}`},
+		{"PUT", "/keys/name/raw", "data", "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"PUT", "/keys/name/raw?expires=60", "new", "If-Match", `"3"`, http.StatusCreated, `{"key":"name","version":4}`},
+		{"PUT", "/keys/name/raw", "data", "If-Match", `3`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		var body io.Reader = http.NoBody
+		if test.Body != "" {
+			body = strings.NewReader(test.Body)
+		}
+		req, err := http.NewRequest(test.Method, "http://127.0.0.1:8080/api/v1"+test.URI, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.Header != "" {
+			req.Header.Set(test.Header, test.Value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s %s (%s: %s): got %v, expected %v", test.Method, test.URI, test.Body, test.Header, test.Value, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+		if rr.Code == http.StatusCreated && rr.Header().Get("ETag") == "" {
+			t.Errorf("ETag must being set for %s %s %s", test.Method, test.URI, test.Body)
+		}
+	}
+}
+
+func TestTxHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"balance","value":"100"}`)
+
+	tests := []struct {
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{`{"ops":[{"op":"incr","key":"balance","delta":-30,"version":1},{"op":"set","key":"order","value":["a","b"],"expires":60},{"op":"remove","key":"missing"}]}`,
+			http.StatusOK, `{"results":[{"op":"incr","key":"balance","ok":true,"value":70,"version":2},{"op":"set","key":"order","ok":true,"version":1},{"op":"remove","key":"missing","ok":false}]}`},
+		{`{"ops":[{"op":"incr","key":"balance","delta":-30,"version":1}]}`, http.StatusPreconditionFailed, ""},
+		{`{"ops":[{"op":"set","key":"balance","value":"0"},{"op":"incr","key":"order","delta":1}]}`, http.StatusConflict, ""},
+		{`{"ops":[{"op":"rename","key":"balance"}]}`, http.StatusBadRequest, ""},
+		{`{"ops":[{"op":"expire","key":"balance"}]}`, http.StatusBadRequest, ""},
+		{`{"ops":[{"op":"set","key":"balance","value":1}]}`, http.StatusBadRequest, ""},
+		{`{"ops":[{"op":"set","key":"","value":"1"}]}`, http.StatusBadRequest, ""},
+		{`{"ops":[]}`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, "POST", "/tx", test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s: got %v, expected %v", test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s: got %v, expected %v", test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/keys/balance/values", ""); !strings.Contains(rr.Body.String(), `"value":"70"`) {
+		t.Errorf("Failed transactions must not change value: got %v", rr.Body.String())
+	}
+}
+
+func TestKeysHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	for _, key := range []string{"users/1", "users/2", "hello"} {
+		doRequest(t, router, "POST", "/keys", `{"key":"`+key+`","value":"value"}`)
+	}
+
+	tests := []struct {
+		URI          string
+		ExpectedCode int
+		ExpectedKeys []string
+	}{
+		{"/keys/h*", http.StatusOK, []string{"hello"}},
+		{"/keys?match=users/*", http.StatusOK, []string{"users/1", "users/2"}},
+		{"/keys", http.StatusOK, []string{"hello", "users/1", "users/2"}},
+		{"/keys?match=" + url.QueryEscape(`^users/\d$`) + "&regex=true", http.StatusOK, []string{"users/1", "users/2"}},
+		{"/keys/missing*", http.StatusOK, []string{}},
+		{"/keys/[", http.StatusBadRequest, nil},
+		{"/keys?match=(&regex=true", http.StatusBadRequest, nil},
+		{"/keys?match=*&regex=maybe", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, "GET", test.URI, "")
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s: got %v, expected %v", test.URI, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedCode != http.StatusOK {
+			continue
+		}
+		resp := &model.APIKeys{}
+		json.NewDecoder(rr.Body).Decode(resp)
+		sort.Strings(resp.Keys)
+		if !reflect.DeepEqual(resp.Keys, test.ExpectedKeys) {
+			t.Errorf("Wrong keys for %s: got %v, expected %v", test.URI, resp.Keys, test.ExpectedKeys)
+		}
+	}
+}
+
+func TestRenameHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"session","value":"data","expires":60}`)
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+
+	tests := []struct {
+		URI          string
+		Body         string
+		ExpectedCode int
+	}{
+		{"/keys/session/rename", `{"destination":"moved"}`, http.StatusOK},
+		{"/keys/session/rename", `{"destination":"other"}`, http.StatusNotFound},
+		{"/keys/moved/rename", `{"destination":"name","nx":true}`, http.StatusPreconditionFailed},
+		{"/keys/moved/copy", `{"destination":"copy"}`, http.StatusOK},
+		{"/keys/moved/copy", `{"destination":"name"}`, http.StatusPreconditionFailed},
+		{"/keys/moved/copy", `{"destination":"name","replace":true}`, http.StatusOK},
+		{"/keys/moved/copy", `{"destination":"moved","replace":true}`, http.StatusBadRequest},
+		{"/keys/moved/copy", `{}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if rr := doRequest(t, router, "POST", test.URI, test.Body); rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s: got %v, expected %v", test.URI, test.Body, rr.Code, test.ExpectedCode)
+		}
+	}
+	for _, key := range []string{"moved", "copy", "name"} {
+		if rr := doRequest(t, router, "GET", "/keys/"+key+"/expires", ""); rr.Code != http.StatusOK {
+			t.Errorf("Expiration time of %s must being carried: got %v, expected %v", key, rr.Code, http.StatusOK)
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/keys/name/values", ""); !strings.Contains(rr.Body.String(), `"value":"data"`) {
+		t.Errorf("Replaced key must hold copied value: got %v", rr.Body.String())
+	}
+}
+
+func TestScanHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	for _, key := range []string{"user:1", "user:2", "user:3", "user:4", "other"} {
+		doRequest(t, router, "POST", "/keys", `{"key":"`+key+`","value":"value"}`)
+	}
+	doRequest(t, router, "POST", "/keys", `{"key":"user:list","value":["a"]}`)
+
+	keys := []string{}
+	cursor := "0"
+	for calls := 0; calls == 0 || cursor != "0"; calls++ {
+		if calls > 10 {
+			t.Fatal("Scan is not finished")
+		}
+		rr := doRequest(t, router, "GET", "/scan?match=user:*&type=string&count=2&cursor="+cursor, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+		}
+		resp := &model.APIScan{}
+		json.NewDecoder(rr.Body).Decode(resp)
+		if len(resp.Keys) > 2 {
+			t.Errorf("Wrong number of keys: got %d, expected at most %d", len(resp.Keys), 2)
+		}
+		keys = append(keys, resp.Keys...)
+		cursor = resp.Cursor
+	}
+	sort.Strings(keys)
+	if expected := []string{"user:1", "user:2", "user:3", "user:4"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Wrong scanned keys: got %v, expected %v", keys, expected)
+	}
+
+	for _, uri := range []string{"/scan?cursor=bad", "/scan?count=-1", "/scan?match=[", "/scan?type=queue"} {
+		if rr := doRequest(t, router, "GET", uri, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Wrong status code for %s: got %v, expected %v", uri, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	//count is limited by maxScanCount, not by maxBatchSize
+	router = newTestRouter(t, &Config{Port: 8080, MaxBatchSize: 1, MaxScanCount: 3})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		doRequest(t, router, "POST", "/keys", `{"key":"`+key+`","value":"value"}`)
+	}
+	total := 0
+	cursor = "0"
+	for calls := 0; calls == 0 || cursor != "0"; calls++ {
+		if calls > 10 {
+			t.Fatal("Scan is not finished")
+		}
+		resp := &model.APIScan{}
+		json.NewDecoder(doRequest(t, router, "GET", "/scan?count=100&cursor="+cursor, "").Body).Decode(resp)
+		if len(resp.Keys) > 3 {
+			t.Errorf("Wrong number of keys: got %d, expected at most %d", len(resp.Keys), 3)
+		}
+		total += len(resp.Keys)
+		cursor = resp.Cursor
+	}
+	if total != 4 {
+		t.Errorf("Wrong number of scanned keys: got %d, expected %d", total, 4)
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/events?match=user:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Wrong content type: got %s, expected %s", ct, "text/event-stream")
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/events?match=user:*", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	doRequest(t, router, "POST", "/keys", `{"key":"other","value":"value"}`)
+	doRequest(t, router, "POST", "/keys", `{"key":"user:1","value":"John Doe"}`)
+	doRequest(t, router, "DELETE", "/keys/user:1", "")
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(resp.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			if strings.HasPrefix(line, "data: ") {
+				lines <- strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	expected := []string{`{"event":"set","key":"user:1","version":1}`, `{"event":"remove","key":"user:1"}`}
+	for _, e := range expected {
+		select {
+		case line := <-lines:
+			if line != e {
+				t.Errorf("Wrong server-sent event: got %s, expected %s", line, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Server-sent event is not received: expected %s", e)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, e := range expected {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(msg)) != e {
+			t.Errorf("Wrong WebSocket event: got %s, expected %s", msg, e)
+		}
+	}
+
+	if rr := doRequest(t, router, "GET", "/events?match=[", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status code for malformed pattern: got %v, expected %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestChannelsHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/channels/subscribe?channel=news&channel=sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Wrong content type: got %s, expected %s", ct, "text/event-stream")
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/channels/subscribe?pattern=user:*&channel=news", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"/channels/news/publish", `{"message":"hello"}`, http.StatusOK, `{"receivers":2}`},
+		{"/channels/user:1/publish", `{"message":"hi"}`, http.StatusOK, `{"receivers":1}`},
+		{"/channels/sport/publish", `{"message":"goal"}`, http.StatusOK, `{"receivers":1}`},
+		{"/channels/other/publish", `{"message":"lost"}`, http.StatusOK, `{"receivers":0}`},
+		{"/channels/news/publish", `message`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, "POST", test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("POST %s: wrong status code: got %v, expected %v", test.URI, rr.Code, test.ExpectedCode)
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("POST %s: wrong body: got %s, expected %s", test.URI, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(resp.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			if strings.HasPrefix(line, "data: ") {
+				lines <- strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	for _, e := range []string{`{"channel":"news","message":"hello"}`, `{"channel":"sport","message":"goal"}`} {
+		select {
+		case line := <-lines:
+			if line != e {
+				t.Errorf("Wrong server-sent message: got %s, expected %s", line, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Server-sent message is not received: expected %s", e)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, e := range []string{`{"channel":"news","message":"hello"}`, `{"channel":"user:1","message":"hi"}`} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(msg)) != e {
+			t.Errorf("Wrong WebSocket message: got %s, expected %s", msg, e)
+		}
+	}
+
+	for _, uri := range []string{"/channels/subscribe", "/channels/subscribe?pattern=["} {
+		if rr := doRequest(t, router, "GET", uri, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: wrong status code: got %v, expected %v", uri, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestBlockingHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+
+	tests := []struct {
+		Method       string
+		URI          string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"GET", "/keys/name/values?wait=10ms&version=0", http.StatusOK, `{"value":"John Doe","type":"string","version":1}`},
+		{"GET", "/keys/name/values?wait=10ms&version=1", http.StatusNotModified, ""},
+		{"GET", "/keys/name/values?version=1", http.StatusNotModified, ""},
+		{"GET", "/keys/name/values?wait=soon", http.StatusBadRequest, ""},
+		{"GET", "/keys/name/values?wait=-1s", http.StatusBadRequest, ""},
+		{"GET", "/keys/name/values?wait=1&version=x", http.StatusBadRequest, ""},
+		{"GET", "/keys/missing/values?wait=10ms", http.StatusNotFound, ""},
+		{"POST", "/keys/jobs/list/lpop?wait=10ms", http.StatusNotFound, ""},
+		{"POST", "/keys/name/list/lpop?wait=10ms", http.StatusConflict, ""},
+	}
+
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, "")
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("%s %s: wrong status code: got %v, expected %v", test.Method, test.URI, rr.Code, test.ExpectedCode)
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("%s %s: wrong body: got %s, expected %s", test.Method, test.URI, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+
+	responses := make(chan string, 2)
+	go func() {
+		rr := doRequest(t, router, "GET", "/keys/name/values?wait=5s&version=1", "")
+		responses <- rr.Body.String()
+	}()
+	go func() {
+		rr := doRequest(t, router, "POST", "/keys/jobs/list/rpop?wait=5", "")
+		responses <- rr.Body.String()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"Jane Doe"}`)
+	doRequest(t, router, "POST", "/keys/jobs/list/rpush", `{"values":["a"]}`)
+	got := []string{<-responses, <-responses}
+	sort.Strings(got)
+	expected := []string{`{"value":"Jane Doe","type":"string","version":2}`, `{"value":"a"}`}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong responses of blocking requests: got %v, expected %v", got, expected)
+	}
+
+	c := &Config{MaxWait: 2}
+	for v, expected := range map[string]time.Duration{"": 0, "500ms": 500 * time.Millisecond, "1": time.Second, "1h": 2 * time.Second} {
+		r := httptest.NewRequest("GET", "/?wait="+v, nil)
+		if wait, err := ParseWait(c, r); err != nil || wait != expected {
+			t.Errorf("Wrong wait %q: got %v %v, expected %v", v, wait, err, expected)
+		}
+	}
+}
+
+func TestBatchHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080, MaxBatchSize: 3})
+	doRequest(t, router, "POST", "/keys", `{"key":"list","value":["a"]}`)
+
+	tests := []struct {
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"/batch/set", `{"items":[{"key":"name","value":"John Doe"},{"key":"blob","value":"AAE=","type":"bytes","pexpires":60000},{"key":"list","value":"a","nx":true}]}`,
+			http.StatusOK, `{"results":[{"key":"name","status":201,"version":1},{"key":"blob","status":201,"version":1},{"key":"list","status":412,"error":{"code":"PreconditionFailed","message":"ERR precondition failed"}}]}`},
+		{"/batch/set", `{"items":[{"key":"","value":"1"},{"key":"number","value":1}]}`,
+			http.StatusOK, `{"results":[{"key":"","status":400,"error":{"code":"BadRequest","message":"Key must being not-empty string"}},{"key":"number","status":400,"error":{"code":"BadRequest","message":"Invalid value"}}]}`},
+		{"/batch/get", `{"keys":["name","blob","missing"]}`,
+			http.StatusOK, `{"results":[{"key":"name","status":200,"value":"John Doe","type":"string","version":1},{"key":"blob","status":200,"value":"AAE=","type":"bytes","version":1},{"key":"missing","status":404,"error":{"code":"NotFound","message":"Key missing not found"}}]}`},
+		{"/batch/remove", `{"keys":["name","missing"]}`,
+			http.StatusOK, `{"results":[{"key":"name","status":200},{"key":"missing","status":404,"error":{"code":"NotFound","message":"Key missing not found"}}]}`},
+		{"/batch/get", `{"keys":["a","b","c","d"]}`, http.StatusRequestEntityTooLarge, ""},
+		{"/batch/remove", `{"keys":[]}`, http.StatusBadRequest, ""},
+		{"/batch/set", `{"items":{}}`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, "POST", test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s: got %v, expected %v", test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s: got %v, expected %v", test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+	if rr := doRequest(t, router, "GET", "/keys/blob/expires", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expiration time of batch item must being set: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestIncrHandler(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+	doRequest(t, router, "POST", "/keys", `{"key":"list","value":["a"]}`)
+
+	tests := []struct {
+		URI           string
+		Body          string
+		ExpectedCode  int
+		ExpectedValue string
+	}{
+		{"/keys/views/incr", "", http.StatusOK, "1"},
+		{"/keys/views/incr", `{"delta":10}`, http.StatusOK, "11"},
+		{"/keys/views/decr", "", http.StatusOK, "10"},
+		{"/keys/views/decr", `{"delta":20}`, http.StatusOK, "-10"},
+		{"/keys/rate/incr", `{"delta":0.5}`, http.StatusOK, "0.5"},
+		{"/keys/rate/incr", `{"delta":1e-1}`, http.StatusOK, "0.6"},
+		{"/keys/views/incr", `{"delta":"ten"}`, http.StatusBadRequest, ""},
+		{"/keys/name/incr", "", http.StatusBadRequest, ""},
+		{"/keys/list/incr", "", http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, "POST", test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s: got %v, expected %v", test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedCode != http.StatusOK {
+			continue
+		}
+		resp := map[string]json.RawMessage{}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if string(resp["value"]) != test.ExpectedValue {
+			t.Errorf("Wrong value for %s %s: got %s, expected %s", test.URI, test.Body, resp["value"], test.ExpectedValue)
+		}
+	}
+
+	doRequest(t, router, "POST", "/keys/limit/incr", `{"pexpires":5000}`)
+	if rr := doRequest(t, router, "GET", "/keys/limit/expires", ""); rr.Code != http.StatusOK {
+		t.Errorf("Initial expiration time must being set: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestListHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"POST", "/keys/jobs/list/rpush", `{"values":["b","c"]}`, http.StatusOK, `{"count":2}`},
+		{"POST", "/keys/jobs/list/lpush", `{"values":["a"]}`, http.StatusOK, `{"count":3}`},
+		{"POST", "/keys/jobs/list/rpush", `{}`, http.StatusBadRequest, ""},
+		{"GET", "/keys/jobs/list?start=1&stop=-1", "", http.StatusOK, `{"value":["b","c"]}`},
+		{"GET", "/keys/jobs/list?start=one", "", http.StatusBadRequest, ""},
+		{"POST", "/keys/jobs/list/insert", `{"pivot":"c","value":"d"}`, http.StatusOK, `{"count":4}`},
+		{"POST", "/keys/jobs/list/insert", `{"pivot":"x","value":"d"}`, http.StatusBadRequest, ""},
+		{"POST", "/keys/jobs/list/set", `{"index":-1,"value":"e"}`, http.StatusOK, `{"message":"OK"}`},
+		{"POST", "/keys/jobs/list/set", `{"index":10,"value":"e"}`, http.StatusBadRequest, ""},
+		{"POST", "/keys/jobs/list/rem", `{"value":"e"}`, http.StatusOK, `{"count":1}`},
+		{"POST", "/keys/jobs/list/lpop", "", http.StatusOK, `{"value":"a"}`},
+		{"POST", "/keys/jobs/list/rpop", "", http.StatusOK, `{"value":"c"}`},
+		{"GET", "/keys/jobs/list/len", "", http.StatusOK, `{"count":1}`},
+		{"POST", "/keys/jobs/list/trim", `{"start":1,"stop":-1}`, http.StatusOK, `{"message":"OK"}`},
+		{"POST", "/keys/jobs/list/lpop", "", http.StatusNotFound, ""},
+		{"POST", "/keys/missing/list/set", `{"value":"e"}`, http.StatusNotFound, ""},
+		{"POST", "/keys/name/list/rpush", `{"values":["a"]}`, http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s: got %v, expected %v", test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s: got %v, expected %v", test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
+
+func TestHashHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"PUT", "/keys/user/fields/name", `{"value":"John"}`, http.StatusOK, `{"count":1}`},
+		{"PUT", "/keys/user/fields/name", `{"value":"Jane"}`, http.StatusOK, `{"count":0}`},
+		{"POST", "/keys/user/fields", `{"fields":{"city":"Moscow","age":"30"}}`, http.StatusOK, `{"count":2}`},
+		{"POST", "/keys/user/fields", `{}`, http.StatusBadRequest, ""},
+		{"GET", "/keys/user/fields/name", "", http.StatusOK, `{"value":"Jane"}`},
+		{"HEAD", "/keys/user/fields/city", "", http.StatusOK, ""},
+		{"POST", "/keys/user/fields/age/incr", `{"delta":5}`, http.StatusOK, `{"key":"user","value":35}`},
+		{"POST", "/keys/user/fields/name/incr", "", http.StatusBadRequest, ""},
+		{"DELETE", "/keys/user/fields/city", "", http.StatusOK, `{"message":"OK"}`},
+		{"DELETE", "/keys/user/fields/city", "", http.StatusNotFound, ""},
+		{"HEAD", "/keys/user/fields/city", "", http.StatusNotFound, ""},
+		{"GET", "/keys/user/fields", "", http.StatusOK, `{"value":{"age":"35","name":"Jane"}}`},
+		{"GET", "/keys/user/fields?keys", "", http.StatusOK, `{"keys":["age","name"]}`},
+		{"GET", "/keys/user/fields?count", "", http.StatusOK, `{"count":2}`},
+		{"GET", "/keys/user/fields/keys", "", http.StatusNotFound, ""},
+		{"GET", "/keys/user/fields/missing", "", http.StatusNotFound, ""},
+		{"PUT", "/keys/name/fields/first", `{"value":"John"}`, http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
+
+func TestSetHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+	doRequest(t, router, "POST", "/keys", `{"key":"name","value":"John Doe"}`)
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"POST", "/keys/a/set/add", `{"members":["x","y","z"]}`, http.StatusOK, `{"count":3}`},
+		{"POST", "/keys/b/set/add", `{"members":["y","z","w"]}`, http.StatusOK, `{"count":3}`},
+		{"POST", "/keys/a/set/add", `{"members":[]}`, http.StatusBadRequest, ""},
+		{"POST", "/keys/a/set/rem", `{"members":["z"]}`, http.StatusOK, `{"count":1}`},
+		{"HEAD", "/keys/a/set/members/x", "", http.StatusOK, ""},
+		{"HEAD", "/keys/a/set/members/z", "", http.StatusNotFound, ""},
+		{"GET", "/keys/a/set", "", http.StatusOK, `{"value":["x","y"],"type":"set"}`},
+		{"GET", "/keys/a/values", "", http.StatusOK, `{"value":["x","y"],"type":"set","version":2}`},
+		{"GET", "/keys/a/set/len", "", http.StatusOK, `{"count":2}`},
+		{"GET", "/sets/inter?key=a&key=b", "", http.StatusOK, `{"value":["y"],"type":"set"}`},
+		{"GET", "/sets/union?key=a&key=b", "", http.StatusOK, `{"value":["w","x","y","z"],"type":"set"}`},
+		{"GET", "/sets/diff?key=b&key=a", "", http.StatusOK, `{"value":["w","z"],"type":"set"}`},
+		{"GET", "/sets/diff", "", http.StatusBadRequest, ""},
+		{"GET", "/sets/union?key=a&key=name", "", http.StatusConflict, ""},
+		{"POST", "/keys/name/set/add", `{"members":["x"]}`, http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
+
+func TestZSetHandlers(t *testing.T) {
+	router := newTestRouter(t, &Config{Port: 8080})
+
+	tests := []struct {
+		Method       string
+		URI          string
+		Body         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"POST", "/keys/board/zset/add", `{"members":[{"member":"john","score":10},{"member":"jane","score":20}]}`, http.StatusOK, `{"count":2}`},
+		{"POST", "/keys/board/zset/add", `{"members":[{"member":"bob","score":15}]}`, http.StatusOK, `{"count":1}`},
+		{"POST", "/keys/board/zset/incr", `{"member":"john","delta":7.5}`, http.StatusOK, `{"key":"board","value":17.5}`},
+		{"GET", "/keys/board/zset/rank/john", "", http.StatusOK, `{"value":1}`},
+		{"GET", "/keys/board/zset/rank/missing", "", http.StatusNotFound, ""},
+		{"GET", "/keys/board/zset?start=-2", "", http.StatusOK, `{"value":[{"member":"john","score":17.5},{"member":"jane","score":20}],"type":"zset"}`},
+		{"GET", "/keys/board/zset?min=15&max=18", "", http.StatusOK, `{"value":[{"member":"bob","score":15},{"member":"john","score":17.5}],"type":"zset"}`},
+		{"GET", "/keys/board/zset?max=-inf", "", http.StatusOK, `{"value":[],"type":"zset"}`},
+		{"GET", "/keys/board/zset?min=low", "", http.StatusBadRequest, ""},
+		{"POST", "/keys/board/zset/rem", `{"members":["bob"]}`, http.StatusOK, `{"count":1}`},
+		{"GET", "/keys/board/zset/len", "", http.StatusOK, `{"count":2}`},
+		{"GET", "/keys/board/values", "", http.StatusOK, `{"value":[{"member":"john","score":17.5},{"member":"jane","score":20}],"type":"zset","version":4}`},
+	}
+	for _, test := range tests {
+		rr := doRequest(t, router, test.Method, test.URI, test.Body)
+		if rr.Code != test.ExpectedCode {
+			t.Errorf("Wrong status code for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Code, test.ExpectedCode)
+			continue
+		}
+		if test.ExpectedBody != "" && rr.Body.String() != test.ExpectedBody {
+			t.Errorf("Wrong body for %s %s %s: got %v, expected %v", test.Method, test.URI, test.Body, rr.Body.String(), test.ExpectedBody)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/andreipimenov/kvstore/config"
 	"github.com/andreipimenov/kvstore/store"
@@ -23,13 +28,61 @@ func main() {
 		c.Port = *port
 	}
 
-	s := NewStore(store.New(c.DumpFile, c.DumpInterval))
+	driver, err := store.New(store.Options{
+		Shards:             c.Shards,
+		DumpFile:           c.DumpFile,
+		DumpInterval:       c.DumpInterval,
+		DumpGenerations:    c.DumpGenerations,
+		DumpFormat:         c.DumpFormat,
+		DumpCompression:    c.DumpCompression,
+		AOFFile:            c.AOFFile,
+		AOFFsync:           c.AOFFsync,
+		AOFRewriteInterval: c.AOFRewriteInterval,
+		Recovery:           c.Recovery,
+		MaxMemory:          c.MaxMemory,
+		MaxKeys:            c.MaxKeys,
+		EvictionPolicy:     c.EvictionPolicy,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := NewStore(driver)
 
 	r := NewRouter(c, s)
 
-	log.Printf("Start listening on port %d", c.Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", c.Port), r)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", c.Port),
+		Handler: r,
+	}
+	//streams of events and messages and blocking requests are not finished by shutdown itself
+	srv.RegisterOnShutdown(driver.CloseSubscriptions)
+	srv.RegisterOnShutdown(s.Broker.Close)
+	srv.RegisterOnShutdown(driver.CloseWaiters)
+	go func() {
+		log.Printf("Start listening on port %d", c.Port)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("Received %s, shutting down", sig)
+
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = 30
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ShutdownTimeout)*time.Second)
+	defer cancel()
+	err = srv.Shutdown(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error shutting down server: %s", err.Error())
+	}
+	err = driver.Close()
+	if err != nil {
+		log.Printf("Error closing storage: %s", err.Error())
 	}
+	log.Println("Server stopped")
 }
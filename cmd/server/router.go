@@ -25,15 +25,117 @@ func NewRouter(c *Config, s *Store) *chi.Mux {
 				r.Use(Authorization(s))
 			}
 
-			r.Get("/{key}/values", GetHandler(s))
+			r.Get("/{key}/values", GetHandler(c, s))
 			r.Get("/{key}/values/{index}", GetIndexHandler(s))
+			r.Get("/{key}/type", TypeHandler(s))
+			r.Get("/{key}/raw", RawGetHandler(s))
+			r.Put("/{key}/raw", RawSetHandler(s))
 			r.Post("/", SetHandler(s))
 
+			r.Get("/", KeysHandler(s))
 			r.Get("/{pattern}", KeysHandler(s))
 			r.Delete("/{key}", RemoveHandler(s))
+			r.Post("/{key}/rename", RenameHandler(s))
+			r.Post("/{key}/copy", CopyHandler(s))
 
 			r.Get("/{key}/expires", GetExpiresHandler(s))
 			r.Post("/{key}/expires", SetExpiresHandler(s))
+			r.Delete("/{key}/expires", PersistHandler(s))
+
+			r.Post("/{key}/incr", IncrHandler(s))
+			r.Post("/{key}/decr", DecrHandler(s))
+
+			r.Get("/{key}/list", RangeHandler(s))
+			r.Get("/{key}/list/len", ListLenHandler(s))
+			r.Post("/{key}/list/lpush", PushHandler(s, true))
+			r.Post("/{key}/list/rpush", PushHandler(s, false))
+			r.Post("/{key}/list/lpop", PopHandler(c, s, true))
+			r.Post("/{key}/list/rpop", PopHandler(c, s, false))
+			r.Post("/{key}/list/trim", TrimHandler(s))
+			r.Post("/{key}/list/set", ListSetHandler(s))
+			r.Post("/{key}/list/rem", ListRemoveHandler(s))
+			r.Post("/{key}/list/insert", ListInsertHandler(s))
+
+			r.Get("/{key}/fields", HashGetAllHandler(s))
+			r.Post("/{key}/fields", HashSetHandler(s))
+			r.Get("/{key}/fields/{field}", FieldGetHandler(s))
+			r.Head("/{key}/fields/{field}", FieldExistsHandler(s))
+			r.Put("/{key}/fields/{field}", FieldSetHandler(s))
+			r.Delete("/{key}/fields/{field}", FieldRemoveHandler(s))
+			r.Post("/{key}/fields/{field}/incr", FieldIncrHandler(s))
+
+			r.Get("/{key}/set", SetMembersHandler(s))
+			r.Get("/{key}/set/len", SetLenHandler(s))
+			r.Head("/{key}/set/members/{member}", SetIsMemberHandler(s))
+			r.Post("/{key}/set/add", SetAddHandler(s, false))
+			r.Post("/{key}/set/rem", SetAddHandler(s, true))
+
+			r.Get("/{key}/zset", ZSetRangeHandler(s))
+			r.Get("/{key}/zset/len", ZSetLenHandler(s))
+			r.Get("/{key}/zset/rank/{member}", ZSetRankHandler(s))
+			r.Post("/{key}/zset/add", ZSetAddHandler(s))
+			r.Post("/{key}/zset/rem", ZSetRemoveHandler(s))
+			r.Post("/{key}/zset/incr", ZSetIncrHandler(s))
+		})
+
+		r.Route("/sets", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Get("/{op:inter|union|diff}", SetCombineHandler(s))
+		})
+
+		r.Route("/scan", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Get("/", ScanHandler(c, s))
+		})
+
+		r.Route("/events", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Get("/", EventsHandler(c, s))
+		})
+
+		r.Route("/channels", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Get("/subscribe", SubscribeHandler(c, s))
+			r.Post("/{name}/publish", PublishHandler(s))
+		})
+
+		r.Route("/batch", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Post("/get", BatchGetHandler(c, s))
+			r.Post("/set", BatchSetHandler(c, s))
+			r.Post("/remove", BatchRemoveHandler(c, s))
+		})
+
+		r.Route("/tx", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Post("/", TxHandler(s))
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			if c.Authorization {
+				r.Use(Authorization(s))
+			}
+
+			r.Get("/stats", StatsHandler(s))
+			r.Post("/snapshot", SnapshotHandler(s))
 		})
 	})
 	return r
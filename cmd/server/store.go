@@ -1,25 +1,93 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/andreipimenov/kvstore/pubsub"
+	"github.com/andreipimenov/kvstore/store"
 )
 
+//ErrInvalidValue - value has unsupported type
+var ErrInvalidValue = errors.New("type of value must being string, []string, map[string]string or bytes")
+
 //Store - key-value storage implementation
 type Store struct {
 	sync.Mutex
 	AuthorizedTokens []string
 	Driver           StoreDriver
+	Broker           *pubsub.Broker
 }
 
 //StoreDriver - interface for store
 type StoreDriver interface {
-	Set(string, interface{})
+	Set(string, interface{}) error
+	SetWithExpires(string, interface{}, int64) error
 	Get(string) (interface{}, bool)
+	GetWithType(string) (interface{}, string, bool)
+	GetEntry(string) (store.Entry, bool)
+	WaitVersion(context.Context, string, uint64) (store.Entry, bool)
+	SetIf(string, interface{}, int64, store.Condition) (uint64, error)
+	RemoveIf(string, store.Condition) (bool, error)
+	Type(string) (string, bool)
 	Remove(string)
-	Keys(string) []string
-	SetExpires(string, int64)
+	Keys(string) ([]string, error)
+	KeysRegexp(string) ([]string, error)
+	Scan(string, string, string, int) ([]string, string, error)
+	Rename(string, string) error
+	RenameNX(string, string) (bool, error)
+	Copy(string, string, bool) (bool, error)
+	Subscribe(string, int) (*store.Subscription, error)
+	SetExpires(string, int64) bool
+	SetPExpires(string, int64) bool
+	SetExpiresAt(string, time.Time) bool
 	GetExpires(string) (int64, bool)
+	GetPExpires(string) (int64, bool)
+	Persist(string) bool
+	IncrBy(string, int64, int64) (int64, error)
+	IncrByFloat(string, float64, int64) (float64, error)
+	LPush(string, ...string) (int, error)
+	RPush(string, ...string) (int, error)
+	LPop(string) (interface{}, bool, error)
+	RPop(string) (interface{}, bool, error)
+	BLPop(context.Context, string) (interface{}, bool, error)
+	BRPop(context.Context, string) (interface{}, bool, error)
+	LIndex(string, int) (interface{}, bool, error)
+	LRange(string, int, int) ([]interface{}, error)
+	LTrim(string, int, int) error
+	LSet(string, int, string) error
+	LRem(string, int, string) (int, error)
+	LLen(string) (int, error)
+	LInsert(string, bool, string, string) (int, error)
+	HSet(string, map[string]string) (int, error)
+	HGet(string, string) (interface{}, bool, error)
+	HDel(string, ...string) (int, error)
+	HExists(string, string) (bool, error)
+	HIncrBy(string, string, int64) (int64, error)
+	HGetAll(string) (map[string]interface{}, error)
+	HKeys(string) ([]string, error)
+	HLen(string) (int, error)
+	SAdd(string, ...string) (int, error)
+	SRem(string, ...string) (int, error)
+	SIsMember(string, string) (bool, error)
+	SMembers(string) (store.Set, error)
+	SCard(string) (int, error)
+	SInter(...string) (store.Set, error)
+	SUnion(...string) (store.Set, error)
+	SDiff(...string) (store.Set, error)
+	ZAdd(string, ...store.ZMember) (int, error)
+	ZIncrBy(string, string, float64) (float64, error)
+	ZRem(string, ...string) (int, error)
+	ZRank(string, string) (int, bool, error)
+	ZCard(string) (int, error)
+	ZRange(string, int, int) (store.ZSet, error)
+	ZRangeByScore(string, float64, float64) (store.ZSet, error)
+	Exec([]store.Op) ([]store.OpResult, error)
+	Stats() map[string]interface{}
+	Save() (int64, error)
 }
 
 //NewStore creates store with specific driver
@@ -27,6 +95,7 @@ func NewStore(driver StoreDriver) *Store {
 	return &Store{
 		AuthorizedTokens: []string{},
 		Driver:           driver,
+		Broker:           pubsub.New(),
 	}
 }
 
@@ -57,10 +126,10 @@ func (s *Store) AddAuthorizedToken(token string) {
 	s.Unlock()
 }
 
-//ValidValue returns true if value is string, slice of strings or map of strings by strings
+//ValidValue returns true if value is string, slice of strings, map of strings by strings or bytes
 func (s *Store) ValidValue(value interface{}) bool {
 	switch x := value.(type) {
-	case string:
+	case string, []byte:
 		return true
 	case []interface{}:
 		for _, v := range x {
@@ -84,10 +153,26 @@ func (s *Store) ValidValue(value interface{}) bool {
 //Set - set key with value
 func (s *Store) Set(key string, value interface{}) error {
 	if !s.ValidValue(value) {
-		return fmt.Errorf("type of value must being string, []string or map[string]string")
+		return ErrInvalidValue
 	}
-	s.Driver.Set(key, value)
-	return nil
+	return s.Driver.Set(key, value)
+}
+
+//SetWithExpires - set key with value and expiration time in milliseconds
+func (s *Store) SetWithExpires(key string, value interface{}, expires int64) error {
+	if !s.ValidValue(value) {
+		return ErrInvalidValue
+	}
+	return s.Driver.SetWithExpires(key, value, expires)
+}
+
+//SetIf - set key with value if condition matches, returns new version
+//Expiration time in milliseconds is set if expires > 0
+func (s *Store) SetIf(key string, value interface{}, expires int64, cond store.Condition) (uint64, error) {
+	if !s.ValidValue(value) {
+		return 0, ErrInvalidValue
+	}
+	return s.Driver.SetIf(key, value, expires, cond)
 }
 
 //Get - get value by key
@@ -98,27 +183,118 @@ func (s *Store) Get(key string) (interface{}, error) {
 	return nil, fmt.Errorf("key %s not found", key)
 }
 
+//GetWithType - get value by key together with its type
+func (s *Store) GetWithType(key string) (interface{}, string, error) {
+	if value, typ, ok := s.Driver.GetWithType(key); ok {
+		return value, typ, nil
+	}
+	return nil, "", fmt.Errorf("key %s not found", key)
+}
+
+//GetEntry - get value by key together with its type and version
+func (s *Store) GetEntry(key string) (store.Entry, error) {
+	if e, ok := s.Driver.GetEntry(key); ok {
+		return e, nil
+	}
+	return store.Entry{}, fmt.Errorf("key %s not found", key)
+}
+
+//WaitEntry - wait until version of key is greater than version or ctx is done, get current value of key
+func (s *Store) WaitEntry(ctx context.Context, key string, version uint64) (store.Entry, error) {
+	if e, ok := s.Driver.WaitVersion(ctx, key, version); ok {
+		return e, nil
+	}
+	return store.Entry{}, fmt.Errorf("key %s not found", key)
+}
+
+//Type - get type of value by key
+func (s *Store) Type(key string) (string, error) {
+	if typ, ok := s.Driver.Type(key); ok {
+		return typ, nil
+	}
+	return "", fmt.Errorf("key %s not found", key)
+}
+
 //Remove - remove key
 func (s *Store) Remove(key string) error {
-	if _, ok := s.Driver.Get(key); ok {
-		s.Driver.Remove(key)
-		return nil
+	return s.RemoveIf(key, store.Condition{})
+}
+
+//RemoveIf - remove key if condition matches
+func (s *Store) RemoveIf(key string, cond store.Condition) error {
+	ok, err := s.Driver.RemoveIf(key, cond)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("key %s not found", key)
 	}
-	return fmt.Errorf("key %s not found", key)
+	return nil
 }
 
-//Keys - get keys by glob pattern
-func (s *Store) Keys(pattern string) ([]string, error) {
-	keys := s.Driver.Keys(pattern)
-	if len(keys) > 0 {
-		return keys, nil
+//Keys - get keys by glob pattern or by regular expression if regex is true
+func (s *Store) Keys(pattern string, regex bool) ([]string, error) {
+	if regex {
+		return s.Driver.KeysRegexp(pattern)
 	}
-	return keys, fmt.Errorf("keys not found by pattern: %s", pattern)
+	return s.Driver.Keys(pattern)
+}
+
+//Scan - iterate keys matching glob pattern and type incrementally, returns keys and cursor of the next call
+func (s *Store) Scan(cursor string, pattern string, typ string, count int) ([]string, string, error) {
+	return s.Driver.Scan(cursor, pattern, typ, count)
+}
+
+//Rename - move value of key with its expiration time to dst
+//If nx is true and dst exists returns store.ErrConditionFailed
+func (s *Store) Rename(key string, dst string, nx bool) error {
+	if !nx {
+		return s.Driver.Rename(key, dst)
+	}
+	ok, err := s.Driver.RenameNX(key, dst)
+	if err == nil && !ok {
+		return store.ErrConditionFailed
+	}
+	return err
+}
+
+//Copy - copy value of key with its expiration time to dst
+//If replace is false and dst exists returns store.ErrConditionFailed
+func (s *Store) Copy(key string, dst string, replace bool) error {
+	ok, err := s.Driver.Copy(key, dst, replace)
+	if err == nil && !ok {
+		return store.ErrConditionFailed
+	}
+	return err
+}
+
+//Subscribe - subscribe to events of keys matching glob pattern, buffer is max number of undelivered events
+func (s *Store) Subscribe(pattern string, buffer int) (*store.Subscription, error) {
+	return s.Driver.Subscribe(pattern, buffer)
 }
 
 //SetExpires set expiration time in seconds for key
-func (s *Store) SetExpires(key string, expires int64) {
-	s.Driver.SetExpires(key, expires)
+func (s *Store) SetExpires(key string, expires int64) error {
+	if !s.Driver.SetExpires(key, expires) {
+		return fmt.Errorf("key %s not found", key)
+	}
+	return nil
+}
+
+//SetPExpires set expiration time in milliseconds for key
+func (s *Store) SetPExpires(key string, expires int64) error {
+	if !s.Driver.SetPExpires(key, expires) {
+		return fmt.Errorf("key %s not found", key)
+	}
+	return nil
+}
+
+//SetExpiresAt set absolute expiration time for key
+func (s *Store) SetExpiresAt(key string, at time.Time) error {
+	if !s.Driver.SetExpiresAt(key, at) {
+		return fmt.Errorf("key %s not found", key)
+	}
+	return nil
 }
 
 //GetExpires returns expiration time in seconds for key
@@ -128,3 +304,266 @@ func (s *Store) GetExpires(key string) (int64, error) {
 	}
 	return 0, fmt.Errorf("expiration time for key %s is not set", key)
 }
+
+//GetPExpires returns expiration time in milliseconds for key
+func (s *Store) GetPExpires(key string) (int64, error) {
+	if expires, ok := s.Driver.GetPExpires(key); ok {
+		return expires, nil
+	}
+	return 0, fmt.Errorf("expiration time for key %s is not set", key)
+}
+
+//Persist removes expiration time for key
+func (s *Store) Persist(key string) error {
+	if !s.Driver.Persist(key) {
+		return fmt.Errorf("expiration time for key %s is not set", key)
+	}
+	return nil
+}
+
+//IncrBy increments integer value of key by delta, expires (milliseconds) is applied to created key only
+func (s *Store) IncrBy(key string, delta int64, expires int64) (int64, error) {
+	return s.Driver.IncrBy(key, delta, expires)
+}
+
+//IncrByFloat increments float value of key by delta, expires (milliseconds) is applied to created key only
+func (s *Store) IncrByFloat(key string, delta float64, expires int64) (float64, error) {
+	return s.Driver.IncrByFloat(key, delta, expires)
+}
+
+//Push adds values to the head (left) or to the tail of list, returns new length
+func (s *Store) Push(key string, values []string, left bool) (int, error) {
+	if left {
+		return s.Driver.LPush(key, values...)
+	}
+	return s.Driver.RPush(key, values...)
+}
+
+//Pop removes and returns first (left) or last item of list
+func (s *Store) Pop(key string, left bool) (interface{}, error) {
+	pop := s.Driver.RPop
+	if left {
+		pop = s.Driver.LPop
+	}
+	value, ok, err := pop(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return value, nil
+}
+
+//BPop removes and returns first (left) or last item of list waiting until list has an item or ctx is done
+func (s *Store) BPop(ctx context.Context, key string, left bool) (interface{}, error) {
+	pop := s.Driver.BRPop
+	if left {
+		pop = s.Driver.BLPop
+	}
+	value, ok, err := pop(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return value, nil
+}
+
+//LIndex returns item of list at index, returns ErrIndexOutOfRange if there is no such item
+func (s *Store) LIndex(key string, index int) (interface{}, error) {
+	value, ok, err := s.Driver.LIndex(key, index)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, store.ErrIndexOutOfRange
+	}
+	return value, nil
+}
+
+//LRange returns items of list from start to stop inclusive
+func (s *Store) LRange(key string, start int, stop int) ([]interface{}, error) {
+	return s.Driver.LRange(key, start, stop)
+}
+
+//LTrim keeps only items of list from start to stop inclusive
+func (s *Store) LTrim(key string, start int, stop int) error {
+	return s.Driver.LTrim(key, start, stop)
+}
+
+//LSet replaces item of list at index
+func (s *Store) LSet(key string, index int, value string) error {
+	return s.Driver.LSet(key, index, value)
+}
+
+//LRem removes count items equal to value from list, returns number of removed items
+func (s *Store) LRem(key string, count int, value string) (int, error) {
+	return s.Driver.LRem(key, count, value)
+}
+
+//LLen returns length of list
+func (s *Store) LLen(key string) (int, error) {
+	return s.Driver.LLen(key)
+}
+
+//LInsert inserts value before or after pivot, returns new length
+func (s *Store) LInsert(key string, before bool, pivot string, value string) (int, error) {
+	return s.Driver.LInsert(key, before, pivot, value)
+}
+
+//HSet sets fields of hash, returns number of added fields
+func (s *Store) HSet(key string, fields map[string]string) (int, error) {
+	return s.Driver.HSet(key, fields)
+}
+
+//HGet returns value of field of hash
+func (s *Store) HGet(key string, field string) (interface{}, error) {
+	value, ok, err := s.Driver.HGet(key, field)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("field %s of key %s not found", field, key)
+	}
+	return value, nil
+}
+
+//HDel removes field of hash
+func (s *Store) HDel(key string, field string) error {
+	n, err := s.Driver.HDel(key, field)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("field %s of key %s not found", field, key)
+	}
+	return nil
+}
+
+//HExists returns true if hash has field
+func (s *Store) HExists(key string, field string) (bool, error) {
+	return s.Driver.HExists(key, field)
+}
+
+//HIncrBy increments integer value of field by delta
+func (s *Store) HIncrBy(key string, field string, delta int64) (int64, error) {
+	return s.Driver.HIncrBy(key, field, delta)
+}
+
+//HGetAll returns all fields and values of hash
+func (s *Store) HGetAll(key string) (map[string]interface{}, error) {
+	return s.Driver.HGetAll(key)
+}
+
+//HKeys returns names of fields of hash
+func (s *Store) HKeys(key string) ([]string, error) {
+	return s.Driver.HKeys(key)
+}
+
+//HLen returns number of fields of hash
+func (s *Store) HLen(key string) (int, error) {
+	return s.Driver.HLen(key)
+}
+
+//SAdd adds members to set, returns number of added members
+func (s *Store) SAdd(key string, members []string) (int, error) {
+	return s.Driver.SAdd(key, members...)
+}
+
+//SRem removes members from set, returns number of removed members
+func (s *Store) SRem(key string, members []string) (int, error) {
+	return s.Driver.SRem(key, members...)
+}
+
+//SIsMember returns true if member is in set
+func (s *Store) SIsMember(key string, member string) (bool, error) {
+	return s.Driver.SIsMember(key, member)
+}
+
+//SMembers returns sorted members of set
+func (s *Store) SMembers(key string) (store.Set, error) {
+	return s.Driver.SMembers(key)
+}
+
+//SCard returns number of members of set
+func (s *Store) SCard(key string) (int, error) {
+	return s.Driver.SCard(key)
+}
+
+//SCombine returns intersection, union or difference of sets
+func (s *Store) SCombine(op string, keys []string) (store.Set, error) {
+	switch op {
+	case "inter":
+		return s.Driver.SInter(keys...)
+	case "union":
+		return s.Driver.SUnion(keys...)
+	case "diff":
+		return s.Driver.SDiff(keys...)
+	default:
+		return nil, fmt.Errorf("unknown set operation %s", op)
+	}
+}
+
+//ZAdd sets scores of members of sorted set, returns number of added members
+func (s *Store) ZAdd(key string, members []store.ZMember) (int, error) {
+	return s.Driver.ZAdd(key, members...)
+}
+
+//ZIncrBy adds delta to score of member, returns new score
+func (s *Store) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	return s.Driver.ZIncrBy(key, member, delta)
+}
+
+//ZRem removes members from sorted set, returns number of removed members
+func (s *Store) ZRem(key string, members []string) (int, error) {
+	return s.Driver.ZRem(key, members...)
+}
+
+//ZRank returns 0-based rank of member ordered by score
+func (s *Store) ZRank(key string, member string) (int, error) {
+	rank, ok, err := s.Driver.ZRank(key, member)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("member %s of key %s not found", member, key)
+	}
+	return rank, nil
+}
+
+//ZCard returns number of members of sorted set
+func (s *Store) ZCard(key string) (int, error) {
+	return s.Driver.ZCard(key)
+}
+
+//ZRange returns members of sorted set with ranks from start to stop inclusive
+func (s *Store) ZRange(key string, start int, stop int) (store.ZSet, error) {
+	return s.Driver.ZRange(key, start, stop)
+}
+
+//ZRangeByScore returns members of sorted set with scores from min to max inclusive
+func (s *Store) ZRangeByScore(key string, min float64, max float64) (store.ZSet, error) {
+	return s.Driver.ZRangeByScore(key, min, max)
+}
+
+//Stats returns storage counters
+func (s *Store) Stats() map[string]interface{} {
+	return s.Driver.Stats()
+}
+
+//Save writes snapshot of storage to dump file, returns size of dump
+func (s *Store) Save() (int64, error) {
+	return s.Driver.Save()
+}
+
+//Exec applies operations of transaction all-or-nothing
+func (s *Store) Exec(ops []store.Op) ([]store.OpResult, error) {
+	for i, op := range ops {
+		if op.Op == store.OpSet && !s.ValidValue(op.Value) {
+			return nil, &store.TxError{Index: i, Err: ErrInvalidValue}
+		}
+	}
+	return s.Driver.Exec(ops)
+}
@@ -1,5 +1,7 @@
 package model
 
+import "encoding/json"
+
 //APIAuth contains login, password and token for authenticated access/interact with storage
 type APIAuth struct {
 	Login    string `json:"login,omitempty"`
@@ -19,9 +21,26 @@ type APIMessage struct {
 }
 
 //APIKeyValue - common server request/response with key and(or) value
+//Optional Expires (seconds) or PExpires (milliseconds) set expiration time together with value
+//Type of value is set in responses, in requests type bytes means value is base64 string
+//Version of value is set in responses, NX sets value only if key does not exist, XX - only if key exists
 type APIKeyValue struct {
-	Key   string      `json:"key,omitempty"`
-	Value interface{} `json:"value,omitempty"`
+	Key      string      `json:"key,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Version  uint64      `json:"version,omitempty"`
+	Expires  int64       `json:"expires,omitempty"`
+	PExpires int64       `json:"pexpires,omitempty"`
+	NX       bool        `json:"nx,omitempty"`
+	XX       bool        `json:"xx,omitempty"`
+}
+
+//APIDestination - request for rename or copy of key into Destination
+//NX renames only if Destination does not exist, Replace allows copy to overwrite existing Destination
+type APIDestination struct {
+	Destination string `json:"destination"`
+	NX          bool   `json:"nx,omitempty"`
+	Replace     bool   `json:"replace,omitempty"`
 }
 
 //APIKeys - server response for multiple APIKeys
@@ -29,7 +48,159 @@ type APIKeys struct {
 	Keys []string `json:"keys"`
 }
 
+//APIScan - server response for scan: part of keys and cursor of the next request ("0" if scan is finished)
+type APIScan struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+//APIEvent - event of key streamed to subscribers: set (with new Version), remove, expire or evict
+//Event dropped with Message is sent before stream of slow subscriber is closed
+type APIEvent struct {
+	Event   string `json:"event"`
+	Key     string `json:"key,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+//APIPublish - request for publishing message into channel
+type APIPublish struct {
+	Message string `json:"message"`
+}
+
+//APIReceivers - server response for publishing: number of subscribers which received message
+type APIReceivers struct {
+	Receivers int `json:"receivers"`
+}
+
+//APIChannelMessage - message of channel streamed to subscribers
+type APIChannelMessage struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+//APISnapshot - server response for on demand snapshot: size in bytes and duration in milliseconds
+type APISnapshot struct {
+	Size     int64 `json:"size"`
+	Duration int64 `json:"duration"`
+}
+
 //APIKeyExpires - struct for request/response expiration time for specific key
+//Expires is set in seconds, PExpires - in milliseconds, ExpireAt - absolute time as RFC3339 string or unix milliseconds
 type APIKeyExpires struct {
-	Expires int64 `json:"expires"`
+	Expires  int64       `json:"expires"`
+	PExpires int64       `json:"pexpires"`
+	ExpireAt interface{} `json:"expireAt,omitempty"`
+}
+
+//APIIncr - request for increment of counter by Delta (1 by default, fractional number increments float value)
+//Optional Expires (seconds) or PExpires (milliseconds) set expiration time of counter when it is created
+type APIIncr struct {
+	Delta    json.Number `json:"delta,omitempty"`
+	Expires  int64       `json:"expires,omitempty"`
+	PExpires int64       `json:"pexpires,omitempty"`
+}
+
+//APIList - request for list operations
+//Values are pushed by push, Value with Index is set by set, Value with Count removed by rem,
+//Value is inserted Before or after Pivot by insert, Start and Stop (inclusive, negative counts from the end) are used by trim
+type APIList struct {
+	Values []string `json:"values,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Index  int      `json:"index,omitempty"`
+	Count  int      `json:"count,omitempty"`
+	Pivot  string   `json:"pivot,omitempty"`
+	Before bool     `json:"before,omitempty"`
+	Start  int      `json:"start,omitempty"`
+	Stop   int      `json:"stop,omitempty"`
+}
+
+//APICount - server response with number of items (length of list, number of removed items)
+type APICount struct {
+	Count int `json:"count"`
+}
+
+//APIHash - request for hash operations: Value of single field or multiple Fields at once
+type APIHash struct {
+	Value  string            `json:"value,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+//APISet - request for set operations with members to add or remove
+type APISet struct {
+	Members []string `json:"members"`
+}
+
+//APIZMember - member of sorted set with its score
+type APIZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+//APIZSet - request for sorted set operations
+//Members are added by add, Member is incremented by Delta by incr
+type APIZSet struct {
+	Members []APIZMember `json:"members,omitempty"`
+	Member  string       `json:"member,omitempty"`
+	Delta   float64      `json:"delta,omitempty"`
+}
+
+//APITxOp - operation of transaction: set, remove, incr or expire
+//Expires (seconds) or PExpires (milliseconds) is expiration time of set, incr (created key only) and expire
+//Version (if > 0) must be equal to version of key before transaction
+type APITxOp struct {
+	Op       string      `json:"op"`
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Delta    int64       `json:"delta,omitempty"`
+	Expires  int64       `json:"expires,omitempty"`
+	PExpires int64       `json:"pexpires,omitempty"`
+	Version  uint64      `json:"version,omitempty"`
+}
+
+//APITx - request with ordered operations of transaction
+type APITx struct {
+	Ops []APITxOp `json:"ops"`
+}
+
+//APITxResult - result of operation of transaction
+//OK is false if removed or expired key does not exist, Value is result of incr, Version - version of key after set or incr
+type APITxResult struct {
+	Op      string      `json:"op"`
+	Key     string      `json:"key"`
+	OK      bool        `json:"ok"`
+	Value   interface{} `json:"value,omitempty"`
+	Version uint64      `json:"version,omitempty"`
+}
+
+//APITxResults - server response with results of operations of transaction
+type APITxResults struct {
+	Results []APITxResult `json:"results"`
+}
+
+//APIBatchKeys - request for batch get or remove of keys
+type APIBatchKeys struct {
+	Keys []string `json:"keys"`
+}
+
+//APIBatchSet - request for batch set, every item is set same as by single set (type, expiration time, nx and xx)
+type APIBatchSet struct {
+	Items []APIKeyValue `json:"items"`
+}
+
+//APIBatchResult - result of batch operation for single key
+//Status is HTTP status code of the same single request, Error is set if operation for key failed
+type APIBatchResult struct {
+	Key     string      `json:"key"`
+	Status  int         `json:"status"`
+	Value   interface{} `json:"value,omitempty"`
+	Type    string      `json:"type,omitempty"`
+	Version uint64      `json:"version,omitempty"`
+	Error   *APIMessage `json:"error,omitempty"`
+}
+
+//APIBatchResults - server response with results of batch operation in order of request
+type APIBatchResults struct {
+	Results []APIBatchResult `json:"results"`
 }
@@ -0,0 +1,190 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/andreipimenov/kvstore/store"
+	"github.com/gobwas/glob"
+)
+
+//DefaultBuffer - number of messages buffered for subscriber if buffer size is not set
+const DefaultBuffer = 256
+
+//ErrNoChannels - subscriber has neither channels nor patterns
+var ErrNoChannels = errors.New("ERR at least one channel or pattern is required")
+
+//Message - message published into channel
+type Message struct {
+	Channel string
+	Payload string
+}
+
+//Subscriber - stream of messages of channels and of channels matching glob patterns
+//Messages are buffered, subscriber which does not read messages fast enough is dropped: messages channel is closed and Dropped returns true
+type Subscriber struct {
+	delivery store.Delivery
+	messages chan Message
+	channels []string
+	patterns []glob.Glob
+	b        *Broker
+}
+
+//Messages returns channel of messages, channel is closed when subscriber is closed or dropped
+//Message published into several channels or patterns of subscriber is delivered once
+func (sub *Subscriber) Messages() <-chan Message {
+	return sub.messages
+}
+
+//Dropped returns true if subscriber is closed because its buffer overflowed
+func (sub *Subscriber) Dropped() bool {
+	return sub.delivery.Dropped()
+}
+
+//Close unsubscribes from all channels and patterns and closes messages channel
+func (sub *Subscriber) Close() {
+	sub.b.remove(sub)
+	sub.close()
+}
+
+func (sub *Subscriber) close() {
+	sub.delivery.Close(sub.closeMessages)
+}
+
+func (sub *Subscriber) closeMessages() {
+	close(sub.messages)
+}
+
+//send delivers message without blocking, returns false if subscriber is closed or its buffer is full and it is dropped
+func (sub *Subscriber) send(m Message) bool {
+	return sub.delivery.Send(func() bool {
+		select {
+		case sub.messages <- m:
+			return true
+		default:
+			return false
+		}
+	}, sub.closeMessages)
+}
+
+//match returns true if channel matches any pattern of subscriber
+func (sub *Subscriber) match(channel string) bool {
+	for _, g := range sub.patterns {
+		if g.Match(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+//Broker - registry of subscribers delivering published messages, messages are not stored:
+//message published into channel without subscribers is lost
+type Broker struct {
+	sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[*Subscriber]struct{}
+}
+
+//New returns broker without subscribers
+func New() *Broker {
+	return &Broker{
+		channels: map[string]map[*Subscriber]struct{}{},
+		patterns: map[*Subscriber]struct{}{},
+	}
+}
+
+//Subscribe returns subscriber of channels and of channels matching glob patterns
+//buffer is max number of undelivered messages (DefaultBuffer if buffer <= 0)
+//Returns ErrNoChannels if there are no channels and patterns and *store.PatternError if pattern is malformed
+func (b *Broker) Subscribe(channels []string, patterns []string, buffer int) (*Subscriber, error) {
+	if len(channels) == 0 && len(patterns) == 0 {
+		return nil, ErrNoChannels
+	}
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	sub := &Subscriber{messages: make(chan Message, buffer), channels: channels, b: b}
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, &store.PatternError{Pattern: pattern, Err: err}
+		}
+		sub.patterns = append(sub.patterns, g)
+	}
+	b.Lock()
+	defer b.Unlock()
+	for _, channel := range channels {
+		subs, ok := b.channels[channel]
+		if !ok {
+			subs = map[*Subscriber]struct{}{}
+			b.channels[channel] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	if len(sub.patterns) > 0 {
+		b.patterns[sub] = struct{}{}
+	}
+	return sub, nil
+}
+
+//Publish delivers payload to subscribers of channel and of patterns matching it without waiting for them
+//Returns number of subscribers which received message, slow subscribers are dropped and not counted
+func (b *Broker) Publish(channel string, payload string) int {
+	m := Message{Channel: channel, Payload: payload}
+	receivers := 0
+	var dropped []*Subscriber
+	b.RLock()
+	subs := b.channels[channel]
+	for sub := range subs {
+		if sub.send(m) {
+			receivers++
+		} else {
+			dropped = append(dropped, sub)
+		}
+	}
+	for sub := range b.patterns {
+		if _, ok := subs[sub]; ok || !sub.match(channel) {
+			continue
+		}
+		if sub.send(m) {
+			receivers++
+		} else {
+			dropped = append(dropped, sub)
+		}
+	}
+	b.RUnlock()
+	for _, sub := range dropped {
+		b.remove(sub)
+	}
+	return receivers
+}
+
+func (b *Broker) remove(sub *Subscriber) {
+	b.Lock()
+	defer b.Unlock()
+	for _, channel := range sub.channels {
+		subs := b.channels[channel]
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.channels, channel)
+		}
+	}
+	delete(b.patterns, sub)
+}
+
+//Close closes all subscribers, messages channels of subscribers are closed
+func (b *Broker) Close() {
+	b.Lock()
+	subs := b.patterns
+	for _, channel := range b.channels {
+		for sub := range channel {
+			subs[sub] = struct{}{}
+		}
+	}
+	b.channels = map[string]map[*Subscriber]struct{}{}
+	b.patterns = map[*Subscriber]struct{}{}
+	b.Unlock()
+	for sub := range subs {
+		sub.close()
+	}
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/andreipimenov/kvstore/store"
+)
+
+//receive returns messages buffered for subscriber
+func receive(sub *Subscriber) []Message {
+	messages := []Message{}
+	for {
+		select {
+		case m, ok := <-sub.Messages():
+			if !ok {
+				return messages
+			}
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := New()
+	news, err := b.Subscribe([]string{"news"}, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, _ := b.Subscribe(nil, []string{"user:*"}, 10)
+	both, _ := b.Subscribe([]string{"news", "user:1"}, []string{"user:*", "*"}, 10)
+
+	tests := []struct {
+		Channel   string
+		Payload   string
+		Receivers int
+	}{
+		{"news", "hello", 2},
+		{"user:1", "hi", 2},
+		{"user:2", "bye", 2},
+		{"other", "lost", 1},
+	}
+	for _, test := range tests {
+		if n := b.Publish(test.Channel, test.Payload); n != test.Receivers {
+			t.Errorf("Wrong number of receivers of %s: got %d, expected %d", test.Channel, n, test.Receivers)
+		}
+	}
+
+	expected := map[*Subscriber][]Message{
+		news:  {{"news", "hello"}},
+		users: {{"user:1", "hi"}, {"user:2", "bye"}},
+		both:  {{"news", "hello"}, {"user:1", "hi"}, {"user:2", "bye"}, {"other", "lost"}},
+	}
+	for sub, messages := range expected {
+		if got := receive(sub); !reflect.DeepEqual(got, messages) {
+			t.Errorf("Wrong messages: got %v, expected %v", got, messages)
+		}
+	}
+
+	both.Close()
+	if _, ok := <-both.Messages(); ok {
+		t.Errorf("Messages channel of closed subscriber must being closed")
+	}
+	if n := b.Publish("user:1", "again"); n != 1 {
+		t.Errorf("Closed subscriber must not receive messages: got %d receivers", n)
+	}
+	if len(b.channels) != 1 || len(b.patterns) != 1 {
+		t.Errorf("Closed subscriber must being removed: got %d channels, %d patterns", len(b.channels), len(b.patterns))
+	}
+
+	if _, err := b.Subscribe(nil, nil, 10); err != ErrNoChannels {
+		t.Errorf("Subscriber without channels must return ErrNoChannels: got %v", err)
+	}
+	if _, err := b.Subscribe(nil, []string{"user:["}, 10); err == nil {
+		t.Errorf("Malformed pattern must return error")
+	} else if _, ok := err.(*store.PatternError); !ok {
+		t.Errorf("Malformed pattern must return *store.PatternError: got %T", err)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := New()
+	slow, _ := b.Subscribe([]string{"jobs"}, nil, 2)
+	fast, _ := b.Subscribe(nil, []string{"jobs"}, 10)
+	for i, expected := range []int{2, 2, 1, 1} {
+		if n := b.Publish("jobs", strconv.Itoa(i)); n != expected {
+			t.Errorf("Wrong number of receivers of message %d: got %d, expected %d", i, n, expected)
+		}
+	}
+	if got := receive(slow); len(got) != 2 || !slow.Dropped() {
+		t.Errorf("Slow subscriber must being dropped after %d messages: got %d messages, dropped %t", 2, len(got), slow.Dropped())
+	}
+	if got := receive(fast); len(got) != 4 || fast.Dropped() {
+		t.Errorf("Fast subscriber must receive all messages: got %d messages, dropped %t", len(got), fast.Dropped())
+	}
+	slow.Close()
+
+	b.Close()
+	if _, ok := <-fast.Messages(); ok || fast.Dropped() {
+		t.Errorf("Subscribers must being closed with broker")
+	}
+	if n := b.Publish("jobs", "lost"); n != 0 {
+		t.Errorf("Message must not being delivered after close: got %d receivers", n)
+	}
+}
+
+func TestPublishParallel(t *testing.T) {
+	b := New()
+	const publishers, messages = 4, 100
+	sub, _ := b.Subscribe(nil, []string{"chan:*"}, publishers*messages)
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channel := "chan:" + strconv.Itoa(i)
+			for j := 0; j < messages; j++ {
+				b.Publish(channel, strconv.Itoa(j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	next := map[string]int{}
+	for _, m := range receive(sub) {
+		if m.Payload != strconv.Itoa(next[m.Channel]) {
+			t.Fatalf("Messages of %s must being delivered in order: got %s, expected %d", m.Channel, m.Payload, next[m.Channel])
+		}
+		next[m.Channel]++
+	}
+	if len(next) != publishers {
+		t.Errorf("Messages of all channels must being delivered: got %d channels", len(next))
+	}
+}
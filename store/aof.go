@@ -0,0 +1,398 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//AOF fsync policies
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+//aofRecord - single operation in append-only log, deadlines are absolute unix milliseconds
+//Transaction is logged as single record with operation "tx" and its records in Ops, so it is replayed all-or-nothing
+//Operations of lists are logged with their arguments: index of lset and linsert, start (Index) and stop of ltrim, count of lrem
+//Time is unix milliseconds of operation: replay checks expiration against it, so key expired before operation is
+//missing for it like it was when record was written (expired keys are not logged as removed)
+type aofRecord struct {
+	Op    string       `json:"op"`
+	Key   string       `json:"key"`
+	Value interface{}  `json:"value,omitempty"`
+	Type  string       `json:"type,omitempty"`
+	At    int64        `json:"at,omitempty"`
+	Index int          `json:"index,omitempty"`
+	Stop  int          `json:"stop,omitempty"`
+	Count int          `json:"count,omitempty"`
+	Ops   []*aofRecord `json:"ops,omitempty"`
+	Time  int64        `json:"time,omitempty"`
+}
+
+//aof - append-only log of write operations
+type aof struct {
+	sync.Mutex
+	rewriteMu sync.Mutex
+	file      *os.File
+	fsync     string
+	dirty     bool
+	rewriting bool
+	buffer    [][]byte
+	rewrites  int64
+	failures  int64
+}
+
+//appendAOF writes operation into log, must be called while key's shard is locked
+//so that records of the same key are logged in the order they are applied
+func (s *Store) appendAOF(rec *aofRecord) {
+	if s.aof == nil {
+		return
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Error encoding AOF record: %s\n", err.Error())
+		return
+	}
+	b = append(b, '\n')
+	s.aof.Lock()
+	defer s.aof.Unlock()
+	if s.aof.file == nil {
+		return
+	}
+	if s.aof.rewriting {
+		s.aof.buffer = append(s.aof.buffer, b)
+	}
+	_, err = s.aof.file.Write(b)
+	if err == nil && s.aof.fsync == FsyncAlways {
+		err = s.aof.file.Sync()
+	}
+	if err != nil {
+		s.aof.failures++
+		log.Printf("Error writing AOF: %s\n", err.Error())
+		return
+	}
+	s.aof.dirty = true
+}
+
+//apply executes logged operation at time of record without writing it to log again
+//Records without time (written by rewrite) are applied at current time
+//Returns error if value of record cannot be restored
+func (s *Store) apply(rec *aofRecord) error {
+	currentTime := rec.Time
+	if currentTime == 0 {
+		currentTime = unixMilli(s.now())
+	}
+	if rec.Op == "tx" {
+		keys := make([]string, len(rec.Ops))
+		for i, op := range rec.Ops {
+			keys[i] = op.Key
+		}
+		unlock := s.lockKeys(keys)
+		defer unlock()
+		for _, op := range rec.Ops {
+			if err := s.shard(op.Key).apply(op, currentTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sh := s.shard(rec.Key)
+	sh.Lock()
+	defer sh.Unlock()
+	return sh.apply(rec, currentTime)
+}
+
+//apply executes single logged operation on shard locked for writing
+func (sh *shard) apply(rec *aofRecord, currentTime int64) error {
+	switch rec.Op {
+	case "set":
+		value, typ, err := storedValue(rec.Type, rec.Value)
+		if err != nil {
+			return err
+		}
+		sh.set(rec.Key, value, typ, currentTime)
+		if rec.At > 0 {
+			sh.setExpires(rec.Key, rec.At)
+		}
+	case "remove":
+		sh.remove(rec.Key)
+	case "expire":
+		if _, ok := sh.data[rec.Key]; ok {
+			sh.setExpires(rec.Key, rec.At)
+		}
+	case "persist":
+		sh.removeExpires(rec.Key)
+	case "sadd", "srem":
+		members, err := stringsOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		if rec.Op == "sadd" {
+			_, err = sh.addSetMembers(rec.Key, members, currentTime)
+		} else {
+			_, err = sh.removeSetMembers(rec.Key, members, currentTime)
+		}
+		return err
+	case "hset":
+		fields, err := fieldsOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		_, err = sh.setHashFields(rec.Key, fields, currentTime)
+		return err
+	case "hdel":
+		fields, err := stringsOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		_, err = sh.removeHashFields(rec.Key, fields, currentTime)
+		return err
+	case "lpush", "rpush":
+		values, err := stringsOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		_, err = sh.pushList(rec.Key, values, rec.Op == "lpush", currentTime)
+		return err
+	case "lpop", "rpop":
+		_, _, err := sh.popList(rec.Key, rec.Op == "lpop", currentTime)
+		return err
+	case "ltrim":
+		return sh.trimList(rec.Key, rec.Index, rec.Stop, currentTime)
+	case "lset", "lrem", "linsert":
+		value, ok := rec.Value.(string)
+		if !ok {
+			return fmt.Errorf("value of %s must be string, got %T", rec.Op, rec.Value)
+		}
+		var err error
+		switch rec.Op {
+		case "lset":
+			err = sh.setListItem(rec.Key, rec.Index, value, currentTime)
+		case "lrem":
+			_, err = sh.removeListItems(rec.Key, rec.Count, value, currentTime)
+		default:
+			_, err = sh.insertListItem(rec.Key, rec.Index, value, currentTime)
+		}
+		return err
+	case "zadd":
+		members, err := zmembersOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		_, err = sh.addZSetMembers(rec.Key, members, currentTime)
+		return err
+	case "zrem":
+		members, err := stringsOf(rec.Value)
+		if err != nil {
+			return err
+		}
+		_, err = sh.removeZSetMembers(rec.Key, members, currentTime)
+		return err
+	default:
+		return fmt.Errorf("unknown operation %s", rec.Op)
+	}
+	return nil
+}
+
+//replayAOF loads log into store, returns false if there is no log file
+//Truncated last record (crash during write) is always cut off, unreadable records
+//in the middle of log are handled according to Recovery mode
+func (s *Store) replayAOF() (bool, error) {
+	f, err := os.Open(s.AOFFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	s.report = LoadReport{File: s.AOFFile, Format: "aof"}
+	if err != nil {
+		return false, s.recover(&s.report, err)
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	var recErr error
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("AOF is truncated, cutting off last %d bytes\n", len(line))
+				if err := os.Truncate(s.AOFFile, offset); err != nil {
+					recErr = err
+				}
+			}
+			break
+		}
+		if err != nil {
+			recErr = err
+			break
+		}
+		offset += int64(len(line))
+		rec := &aofRecord{}
+		err = json.Unmarshal(line, rec)
+		if err == nil {
+			err = s.apply(rec)
+		}
+		if err != nil {
+			s.report.Skipped++
+			if recErr == nil {
+				recErr = fmt.Errorf("unreadable record at offset %d: %s", offset-int64(len(line)), err.Error())
+			}
+		}
+	}
+	f.Close()
+	if recErr != nil {
+		if err := s.recover(&s.report, recErr); err != nil {
+			return false, err
+		}
+		if s.report.Quarantined != "" {
+			return false, nil
+		}
+	}
+	s.report.Keys = s.keysCount()
+	return true, nil
+}
+
+//openAOF starts logging, creates log from current dataset if it does not exist
+func (s *Store) openAOF(loaded bool) error {
+	s.aof = &aof{
+		fsync: s.AOFFsync,
+	}
+	if !loaded {
+		if err := s.RewriteAOF(); err != nil {
+			s.aof = nil
+			return fmt.Errorf("cannot create AOF: %s", err.Error())
+		}
+		return nil
+	}
+	f, err := os.OpenFile(s.AOFFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.aof = nil
+		return fmt.Errorf("cannot open AOF: %s", err.Error())
+	}
+	s.aof.file = f
+	return nil
+}
+
+//RewriteAOF compacts log: writes current dataset into new file, then appends operations
+//made during rewrite and atomically replaces old log
+func (s *Store) RewriteAOF() error {
+	s.aof.rewriteMu.Lock()
+	defer s.aof.rewriteMu.Unlock()
+	//Switch to buffering while all shards are locked: every operation is either
+	//in snapshot or in buffer, never in both
+	d := s.snapshotWith(func() {
+		s.aof.Lock()
+		s.aof.rewriting = true
+		s.aof.buffer = nil
+		s.aof.Unlock()
+	})
+	defer func() {
+		s.aof.Lock()
+		s.aof.rewriting = false
+		s.aof.buffer = nil
+		s.aof.Unlock()
+	}()
+
+	dir := filepath.Dir(s.AOFFile)
+	f, err := ioutil.TempFile(dir, filepath.Base(s.AOFFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	w := bufio.NewWriter(f)
+	e := json.NewEncoder(w)
+	for key, value := range d.Data {
+		err = e.Encode(&aofRecord{Op: "set", Key: key, Value: value, Type: d.Types[key], At: d.PExpires[key]})
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = f.Sync(); err != nil {
+		return fail(err)
+	}
+
+	s.aof.Lock()
+	defer s.aof.Unlock()
+	for _, b := range s.aof.buffer {
+		if _, err = f.Write(b); err != nil {
+			return fail(err)
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err = os.Rename(f.Name(), s.AOFFile); err != nil {
+		return fail(err)
+	}
+	syncDir(dir)
+	if s.aof.file != nil {
+		s.aof.file.Close()
+	}
+	s.aof.file = f
+	s.aof.dirty = false
+	s.aof.rewrites++
+	return nil
+}
+
+//closeAOF syncs and closes log, operations made after closing are not logged
+func (s *Store) closeAOF() error {
+	s.aof.rewriteMu.Lock()
+	defer s.aof.rewriteMu.Unlock()
+	s.aof.Lock()
+	defer s.aof.Unlock()
+	if s.aof.file == nil {
+		return nil
+	}
+	err := s.aof.file.Sync()
+	if closeErr := s.aof.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.aof.file = nil
+	return err
+}
+
+//aofWorker syncs log every second with "everysec" policy and rewrites it every AOFRewriteInterval seconds
+func (s *Store) aofWorker() {
+	defer s.workers.Done()
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	lastRewrite := time.Now()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if s.aof.fsync == FsyncEverySec {
+			s.aof.Lock()
+			if s.aof.dirty {
+				if err := s.aof.file.Sync(); err != nil {
+					s.aof.failures++
+					log.Printf("Error syncing AOF: %s\n", err.Error())
+				}
+				s.aof.dirty = false
+			}
+			s.aof.Unlock()
+		}
+		if s.AOFRewriteInterval > 0 && time.Since(lastRewrite) >= time.Duration(s.AOFRewriteInterval)*time.Second {
+			if err := s.RewriteAOF(); err != nil {
+				log.Printf("Error rewriting AOF: %s\n", err.Error())
+			}
+			lastRewrite = time.Now()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+//Errors of counter operations
+var (
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat   = errors.New("ERR value is not a valid float")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+)
+
+//Incr increments integer value of key by one
+func (s *Store) Incr(key string) (int64, error) {
+	return s.IncrBy(key, 1, 0)
+}
+
+//Decr decrements integer value of key by one
+func (s *Store) Decr(key string) (int64, error) {
+	return s.IncrBy(key, -1, 0)
+}
+
+//counterValue returns string value of item which can be incremented (string or counter)
+func counterValue(it *item) (string, bool) {
+	if it.typ != TypeString && it.typ != TypeCounter {
+		return "", false
+	}
+	str, ok := it.value.(string)
+	return str, ok
+}
+
+//IncrBy atomically adds delta to integer value stored as string and returns new value
+//Missing key is created with value 0 before increment and expiration time in milliseconds (if expires > 0)
+func (s *Store) IncrBy(key string, delta int64, expires int64) (int64, error) {
+	if err := s.reserve(key, ""); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	it, exists := sh.lookup(key, currentTime)
+	current, err := incrInt(it, delta)
+	if err != nil {
+		return 0, err
+	}
+	s.setCounter(sh, key, strconv.FormatInt(current, 10), !exists, expires, currentTime)
+	return current, nil
+}
+
+//incrInt returns integer value of item (0 if item is nil) increased by delta
+func incrInt(it *item, delta int64) (int64, error) {
+	var current int64
+	if it != nil {
+		str, ok := counterValue(it)
+		if !ok {
+			return 0, ErrWrongType
+		}
+		var err error
+		if current, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+		return 0, ErrOverflow
+	}
+	return current + delta, nil
+}
+
+//IncrByFloat atomically adds delta to float value stored as string and returns new value
+//Missing key is created with value 0 before increment and expiration time in milliseconds (if expires > 0)
+func (s *Store) IncrByFloat(key string, delta float64, expires int64) (float64, error) {
+	if err := s.reserve(key, ""); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	var current float64
+	it, exists := sh.lookup(key, currentTime)
+	if exists {
+		str, ok := counterValue(it)
+		if !ok {
+			return 0, ErrWrongType
+		}
+		var err error
+		if current, err = strconv.ParseFloat(str, 64); err != nil {
+			return 0, ErrNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return 0, ErrOverflow
+	}
+	s.setCounter(sh, key, strconv.FormatFloat(current, 'f', -1, 64), !exists, expires, currentTime)
+	return current, nil
+}
+
+//setCounter stores new counter value, sets initial expiration time for created key
+//Shard must be locked for writing
+func (s *Store) setCounter(sh *shard, key string, value string, created bool, expires int64, currentTime int64) {
+	sh.set(key, value, TypeCounter, currentTime)
+	rec := &aofRecord{Op: "set", Key: key, Value: value, Type: TypeCounter, Time: currentTime}
+	if created && expires > 0 {
+		sh.setExpires(key, currentTime+expires)
+		rec.At = currentTime + expires
+	}
+	s.appendAOF(rec)
+}
@@ -0,0 +1,401 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//dump - on-disk representation of store
+//Expires holds unix seconds deadlines of legacy dumps, PExpires - unix milliseconds deadlines
+//Types holds types of values which cannot be restored from JSON by themselves (sets, sorted sets, counters, bytes)
+//Bytes are written into JSON as base64 strings
+type dump struct {
+	Data     map[string]interface{} `json:"data"`
+	Types    map[string]string      `json:"types,omitempty"`
+	Expires  map[string]int64       `json:"expires,omitempty"`
+	PExpires map[string]int64       `json:"pexpires"`
+}
+
+//ErrNoDumpFile - dump is requested but dump file is not configured
+var ErrNoDumpFile = errors.New("dump file is not configured")
+
+//dumpStats - counters of dump saves exposed by Stats
+type dumpStats struct {
+	sync.Mutex
+	saves        int64
+	failures     int64
+	lastError    string
+	lastSize     int64
+	lastDuration time.Duration
+	lastSave     time.Time
+}
+
+//cloneValue returns copy of value which does not share memory with stored one
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		copy(c, v)
+		return c
+	case []string:
+		c := make([]string, len(v))
+		copy(c, v)
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			c[key] = item
+		}
+		return c
+	case *listValue:
+		return v.values()
+	case setValue:
+		return v.members()
+	case *zsetValue:
+		return v.members()
+	default:
+		return value
+	}
+}
+
+//snapshot returns point-in-time copy of all shards
+//All shards are locked for reading together, so no write is visible partially
+func (s *Store) snapshot() *dump {
+	return s.snapshotWith(nil)
+}
+
+//snapshotWith returns snapshot and calls locked (if not nil) while all shards are still locked
+func (s *Store) snapshotWith(locked func()) *dump {
+	d := &dump{
+		Data:     map[string]interface{}{},
+		Types:    map[string]string{},
+		PExpires: map[string]int64{},
+	}
+	for _, sh := range s.shards {
+		sh.RLock()
+	}
+	currentTime := unixMilli(s.now())
+	for _, sh := range s.shards {
+		for key, it := range sh.data {
+			if !sh.expired(key, currentTime) {
+				d.Data[key] = cloneValue(it.value)
+				if !jsonType(it.typ) {
+					d.Types[key] = it.typ
+				}
+			}
+		}
+		for key, t := range sh.expires {
+			if currentTime < t.at {
+				d.PExpires[key] = t.at
+			}
+		}
+	}
+	if locked != nil {
+		locked()
+	}
+	for _, sh := range s.shards {
+		sh.RUnlock()
+	}
+	return d
+}
+
+//MarshalJSON dumps all shards into single object with data and expires
+func (s *Store) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.snapshot())
+}
+
+//UnmarshalJSON loads dump and distributes keys by shards
+func (s *Store) UnmarshalJSON(b []byte) error {
+	d := &dump{}
+	err := json.Unmarshal(b, d)
+	if err != nil {
+		return err
+	}
+	_, err = s.loadDump(d, false)
+	return err
+}
+
+//loadDump distributes keys of decoded dump by shards
+//Value which cannot be decoded is an error, in partial mode such keys are skipped and counted
+//and the first error is returned after all other keys are loaded
+func (s *Store) loadDump(d *dump, partial bool) (int, error) {
+	currentTime := unixMilli(s.now())
+	skipped := 0
+	var loadErr error
+	for key, value := range d.Data {
+		value, typ, err := storedValue(d.Types[key], value)
+		if err != nil {
+			err = fmt.Errorf("cannot load key %s: %s", key, err.Error())
+			if !partial {
+				return skipped, err
+			}
+			log.Println(err.Error())
+			skipped++
+			if loadErr == nil {
+				loadErr = err
+			}
+			continue
+		}
+		sh := s.shard(key)
+		sh.Lock()
+		sh.set(key, value, typ, currentTime)
+		sh.Unlock()
+	}
+	for key, value := range d.Expires {
+		sh := s.shard(key)
+		sh.Lock()
+		if _, ok := sh.data[key]; ok {
+			sh.setExpires(key, value*1000)
+		}
+		sh.Unlock()
+	}
+	for key, value := range d.PExpires {
+		sh := s.shard(key)
+		sh.Lock()
+		if _, ok := sh.data[key]; ok {
+			sh.setExpires(key, value)
+		}
+		sh.Unlock()
+	}
+	return skipped, loadErr
+}
+
+//generation returns file name of n-th previous dump
+func (s *Store) generation(n int) string {
+	return fmt.Sprintf("%s.%d", s.DumpFile, n)
+}
+
+//load reads dump file, falls back to latest previous generation if dump file is missing
+//Format (binary snapshot or legacy JSON) is detected by file contents
+//Missing dump is not an error, corrupt or unreadable one is handled according to Recovery mode
+func (s *Store) load() error {
+	file := s.DumpFile
+	if _, err := os.Stat(file); os.IsNotExist(err) && s.DumpGenerations > 0 {
+		if _, err := os.Stat(s.generation(1)); err == nil {
+			file = s.generation(1)
+		}
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	s.report = LoadReport{File: file}
+	d, err := s.readDump(file, &s.report)
+	if d != nil && (err == nil || s.Recovery == RecoveryPartial) {
+		skipped, loadErr := s.loadDump(d, s.Recovery == RecoveryPartial)
+		s.report.Skipped += skipped
+		s.report.Keys = len(d.Data) - skipped
+		if err == nil {
+			err = loadErr
+		}
+	}
+	if err != nil {
+		return s.recover(&s.report, err)
+	}
+	return nil
+}
+
+//readDump decodes dump file in binary or JSON format
+//In partial recovery mode returns all valid entries together with error
+func (s *Store) readDump(file string, report *LoadReport) (*dump, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	head, _ := r.Peek(len(snapshotMagic))
+	partial := s.Recovery == RecoveryPartial
+	if isBinarySnapshot(head) {
+		report.Format = FormatBinary
+		d, skipped, err := readSnapshot(r, partial)
+		report.Skipped = skipped
+		return d, err
+	}
+	report.Format = FormatJSON
+	if partial {
+		d, skipped, err := readJSONPartial(r)
+		report.Skipped = skipped
+		return d, err
+	}
+	d := &dump{}
+	err = json.NewDecoder(r).Decode(d)
+	return d, err
+}
+
+//Save writes snapshot into temporary file, syncs it to disk and atomically replaces dump file
+//Previous dumps are kept according to DumpGenerations. Returns size of written dump
+func (s *Store) Save() (int64, error) {
+	if s.DumpFile == "" {
+		return 0, ErrNoDumpFile
+	}
+	start := time.Now()
+	size, err := s.save()
+	s.stats.Lock()
+	if err != nil {
+		s.stats.failures++
+		s.stats.lastError = err.Error()
+	} else {
+		s.stats.saves++
+		s.stats.lastError = ""
+		s.stats.lastSize = size
+		s.stats.lastDuration = time.Since(start)
+		s.stats.lastSave = start
+	}
+	s.stats.Unlock()
+	return size, err
+}
+
+//save implements Save without updating stats
+//Saves are serialized (periodic, requested by admin and final one), so snapshots replace dump file in the order they are taken
+func (s *Store) save() (int64, error) {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+	d := s.snapshot()
+	dir := filepath.Dir(s.DumpFile)
+	f, err := ioutil.TempFile(dir, filepath.Base(s.DumpFile)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmp := f.Name()
+	w := bufio.NewWriter(f)
+	if s.DumpFormat == FormatJSON {
+		err = json.NewEncoder(w).Encode(d)
+	} else {
+		err = writeSnapshot(w, d, s.DumpCompression)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if err == nil {
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if fi != nil {
+			size = fi.Size()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err = s.rotate(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err = os.Rename(tmp, s.DumpFile); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	syncDir(dir)
+	return size, nil
+}
+
+//rotate shifts previous dumps: DumpFile.N-1 -> DumpFile.N, ..., DumpFile -> DumpFile.1
+func (s *Store) rotate() error {
+	if s.DumpGenerations <= 0 {
+		return nil
+	}
+	for n := s.DumpGenerations - 1; n >= 0; n-- {
+		from := s.DumpFile
+		if n > 0 {
+			from = s.generation(n)
+		}
+		err := os.Rename(from, s.generation(n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//syncDir flushes directory entry so that rename survives crash
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+//dumpWorker saves store to file
+func (s *Store) dumpWorker() {
+	defer s.workers.Done()
+	ticker := time.NewTicker(time.Duration(s.DumpInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		_, err := s.Save()
+		if err != nil {
+			log.Printf("Error saving storage dump: %s\n", err.Error())
+		}
+	}
+}
+
+//keysCount returns number of keys in all shards (including expired but not yet removed)
+func (s *Store) keysCount() int {
+	return int(atomic.LoadInt64(&s.mem.keys))
+}
+
+//Stats returns number of keys, dump counters and report of data loaded at startup
+func (s *Store) Stats() map[string]interface{} {
+	keys := s.keysCount()
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	stats := map[string]interface{}{
+		"keys":             keys,
+		"dumpSaves":        s.stats.saves,
+		"dumpFailures":     s.stats.failures,
+		"dumpLastSize":     s.stats.lastSize,
+		"dumpLastDuration": int64(s.stats.lastDuration / time.Millisecond),
+		"usedMemory":       atomic.LoadInt64(&s.mem.used),
+		"maxMemory":        s.MaxMemory,
+		"maxKeys":          s.MaxKeys,
+		"evictionPolicy":   s.EvictionPolicy,
+		"evictedKeys":      atomic.LoadInt64(&s.mem.evicted),
+		"rejectedWrites":   atomic.LoadInt64(&s.mem.rejected),
+	}
+	if !s.stats.lastSave.IsZero() {
+		stats["dumpLastSave"] = s.stats.lastSave.UTC().Format(time.RFC3339)
+	}
+	if s.stats.lastError != "" {
+		stats["dumpLastError"] = s.stats.lastError
+	}
+	if s.report.File != "" {
+		stats["loadFile"] = s.report.File
+		stats["loadKeys"] = s.report.Keys
+		stats["loadSkipped"] = s.report.Skipped
+		if s.report.Quarantined != "" {
+			stats["loadQuarantined"] = s.report.Quarantined
+		}
+		if s.report.Error != "" {
+			stats["loadError"] = s.report.Error
+		}
+	}
+	if s.aof != nil {
+		s.aof.Lock()
+		stats["aofRewrites"] = s.aof.rewrites
+		stats["aofFailures"] = s.aof.failures
+		s.aof.Unlock()
+	}
+	return stats
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+)
+
+//Eviction policies
+//PolicyNoEviction rejects writes when limit is reached, allkeys policies evict any key,
+//volatile policies evict only keys with expiration time
+const (
+	PolicyNoEviction  = "noeviction"
+	PolicyAllKeysLRU  = "allkeys-lru"
+	PolicyAllKeysLFU  = "allkeys-lfu"
+	PolicyVolatileLRU = "volatile-lru"
+	PolicyVolatileTTL = "volatile-ttl"
+)
+
+//ErrOutOfMemory - write is rejected because store reached its limits and there is nothing to evict
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > maxMemory")
+
+const (
+	//evictionSamples - number of shards and keys per shard sampled to choose eviction candidate
+	evictionSamples = 5
+	//itemOverhead - approximate memory used by map entry, item and ttl structures
+	itemOverhead = 96
+	//lfuInitial - access counter of new key, so that it is not evicted immediately
+	lfuInitial = 5
+	//lfuLogFactor - counter grows logarithmically: (counter-lfuInitial)*lfuLogFactor+1 hits needed to increment
+	lfuLogFactor = 10
+	//lfuDecayTime - counter is decremented by one for every lfuDecayTime milliseconds without access
+	lfuDecayTime = 60000
+)
+
+//memory - approximate memory usage and eviction counters shared by all shards
+type memory struct {
+	used     int64
+	keys     int64
+	evicted  int64
+	rejected int64
+}
+
+//add changes used memory and number of keys
+func (m *memory) add(size int64, keys int64) {
+	atomic.AddInt64(&m.used, size)
+	if keys != 0 {
+		atomic.AddInt64(&m.keys, keys)
+	}
+}
+
+//sizeOf returns approximate memory used by value
+func sizeOf(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(v)) + 16
+	case []byte:
+		return int64(len(v)) + 24
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += sizeOf(item)
+		}
+		return size
+	case []string:
+		size := int64(24)
+		for _, item := range v {
+			size += int64(len(item)) + 16
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(48)
+		for key, item := range v {
+			size += int64(len(key)) + 16 + sizeOf(item)
+		}
+		return size
+	case map[string]string:
+		size := int64(48)
+		for key, item := range v {
+			size += int64(len(key)) + int64(len(item)) + 32
+		}
+		return size
+	case *listValue:
+		size := int64(24)
+		for i := 0; i < v.len(); i++ {
+			size += sizeOf(v.at(i))
+		}
+		return size
+	case setValue:
+		size := int64(48)
+		for member := range v {
+			size += memberSize(member)
+		}
+		return size
+	case *zsetValue:
+		size := int64(48)
+		for member := range v.scores {
+			size += zmemberSize(member)
+		}
+		return size
+	default:
+		return 16
+	}
+}
+
+//itemSize returns approximate memory used by key with value
+func itemSize(key string, value interface{}) int64 {
+	return int64(len(key)) + sizeOf(value) + itemOverhead
+}
+
+//limited returns true if store has memory or keys limit
+func (s *Store) limited() bool {
+	return s.MaxMemory > 0 || s.MaxKeys > 0
+}
+
+//touch updates access statistics of item, safe to call with shard locked for reading
+func (s *Store) touch(it *item, currentTime int64) {
+	if !s.limited() {
+		return
+	}
+	switch s.EvictionPolicy {
+	case PolicyAllKeysLFU:
+		freq := it.lfu(currentTime)
+		step := uint32((int64(freq)-lfuInitial)*lfuLogFactor + 1)
+		if freq < lfuInitial {
+			step = 1
+		}
+		if freq < 255 && atomic.AddUint32(&it.hits, 1)%step == 0 {
+			atomic.StoreUint32(&it.freq, freq+1)
+		} else {
+			atomic.StoreUint32(&it.freq, freq)
+		}
+	}
+	atomic.StoreInt64(&it.access, currentTime)
+}
+
+//lfu returns access counter decayed by time passed since last access
+func (it *item) lfu(currentTime int64) uint32 {
+	freq := int64(atomic.LoadUint32(&it.freq))
+	freq -= (currentTime - atomic.LoadInt64(&it.access)) / lfuDecayTime
+	if freq < 0 {
+		freq = 0
+	}
+	return uint32(freq)
+}
+
+//overLimit returns true if store does not fit limits after adding size bytes and keys
+func (s *Store) overLimit(size int64, keys int64) bool {
+	if s.MaxMemory > 0 && atomic.LoadInt64(&s.mem.used)+size > s.MaxMemory {
+		return true
+	}
+	return s.MaxKeys > 0 && atomic.LoadInt64(&s.mem.keys)+keys > s.MaxKeys
+}
+
+//reserve evicts keys according to policy until value fits limits
+//Must be called without any shard locked, limits are approximate under concurrent writes
+func (s *Store) reserve(key string, value interface{}) error {
+	if !s.limited() {
+		return nil
+	}
+	return s.reserveSize(key, itemSize(key, value))
+}
+
+//reserveSize evicts keys according to policy until item of size bytes fits limits
+//Must be called without any shard locked
+func (s *Store) reserveSize(key string, size int64) error {
+	total, keys := size, int64(1)
+	sh := s.shard(key)
+	sh.RLock()
+	if it, ok := sh.data[key]; ok {
+		size -= it.size
+		keys = 0
+	}
+	sh.RUnlock()
+	return s.free(size, keys, total)
+}
+
+//grow checks limits before value of key grows by size bytes (key is created if it does not exist)
+func (s *Store) grow(key string, size int64) error {
+	if !s.limited() {
+		return nil
+	}
+	total, keys := size, int64(0)
+	sh := s.shard(key)
+	sh.RLock()
+	if it, ok := sh.data[key]; ok {
+		total += it.size
+	} else {
+		size += itemSize(key, nil)
+		total, keys = size, 1
+	}
+	sh.RUnlock()
+	return s.free(size, keys, total)
+}
+
+//free evicts keys until size bytes and number of keys can be added without exceeding limits
+//total is size of the whole item after write, item which does not fit limits even in empty store is rejected without eviction
+func (s *Store) free(size int64, keys int64, total int64) error {
+	if s.MaxMemory > 0 && total > s.MaxMemory || s.MaxKeys > 0 && keys > s.MaxKeys {
+		atomic.AddInt64(&s.mem.rejected, 1)
+		return ErrOutOfMemory
+	}
+	for s.overLimit(size, keys) {
+		candidate, ok := "", false
+		if s.EvictionPolicy != PolicyNoEviction {
+			candidate, ok = s.evictionCandidate()
+		}
+		if !ok {
+			atomic.AddInt64(&s.mem.rejected, 1)
+			return ErrOutOfMemory
+		}
+		s.evict(candidate)
+	}
+	return nil
+}
+
+//evictionCandidate samples keys from random shards and returns the best one to evict by policy
+func (s *Store) evictionCandidate() (string, bool) {
+	var best string
+	var bestScore int64
+	found := false
+	currentTime := unixMilli(s.now())
+	consider := func(key string, score int64) {
+		if !found || score < bestScore {
+			best, bestScore, found = key, score, true
+		}
+	}
+	for i := 0; i < evictionSamples; i++ {
+		sh := s.shards[rand.Intn(len(s.shards))]
+		sh.RLock()
+		n := 0
+		switch s.EvictionPolicy {
+		case PolicyVolatileTTL:
+			if len(sh.ttls) > 0 {
+				consider(sh.ttls[0].key, sh.ttls[0].at)
+			}
+		case PolicyVolatileLRU:
+			for key := range sh.expires {
+				if it, ok := sh.data[key]; ok {
+					consider(key, atomic.LoadInt64(&it.access))
+				}
+				if n++; n == evictionSamples {
+					break
+				}
+			}
+		case PolicyAllKeysLRU:
+			for key, it := range sh.data {
+				consider(key, atomic.LoadInt64(&it.access))
+				if n++; n == evictionSamples {
+					break
+				}
+			}
+		case PolicyAllKeysLFU:
+			for key, it := range sh.data {
+				consider(key, int64(it.lfu(currentTime)))
+				if n++; n == evictionSamples {
+					break
+				}
+			}
+		}
+		sh.RUnlock()
+	}
+	if !found && s.EvictionPolicy != PolicyNoEviction {
+		//Sampled shards may be empty while others are not, fall back to full scan of shards
+		for _, sh := range s.shards {
+			sh.RLock()
+			if s.EvictionPolicy == PolicyVolatileTTL || s.EvictionPolicy == PolicyVolatileLRU {
+				if len(sh.ttls) > 0 {
+					best, found = sh.ttls[0].key, true
+				}
+			} else {
+				for key := range sh.data {
+					best, found = key, true
+					break
+				}
+			}
+			sh.RUnlock()
+			if found {
+				break
+			}
+		}
+	}
+	return best, found
+}
+
+//evict removes key chosen by eviction policy
+func (s *Store) evict(key string) {
+	sh := s.shard(key)
+	sh.Lock()
+	if _, ok := sh.data[key]; ok {
+		sh.removeAs(key, EventEvict)
+		s.appendAOF(&aofRecord{Op: "remove", Key: key})
+		atomic.AddInt64(&s.mem.evicted, 1)
+	}
+	sh.Unlock()
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+//expiresInterval - how often expiresWorker collects expired keys
+const expiresInterval = 100 * time.Millisecond
+
+//unixMilli returns t as number of milliseconds since unix epoch
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+//ttl - expiration deadline of key (unix milliseconds), element of ttlHeap
+type ttl struct {
+	key   string
+	at    int64
+	index int
+}
+
+//ttlHeap - min-heap of deadlines, implements heap.Interface
+type ttlHeap []*ttl
+
+func (h ttlHeap) Len() int { return len(h) }
+
+func (h ttlHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+
+func (h ttlHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ttlHeap) Push(x interface{}) {
+	t := x.(*ttl)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *ttlHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	t.index = -1
+	return t
+}
+
+//setExpires sets deadline for key, shard must be locked for writing
+func (sh *shard) setExpires(key string, at int64) {
+	if t, ok := sh.expires[key]; ok {
+		t.at = at
+		heap.Fix(&sh.ttls, t.index)
+		return
+	}
+	t := &ttl{key: key, at: at}
+	heap.Push(&sh.ttls, t)
+	sh.expires[key] = t
+}
+
+//removeExpires drops deadline of key, shard must be locked for writing
+func (sh *shard) removeExpires(key string) {
+	if t, ok := sh.expires[key]; ok {
+		heap.Remove(&sh.ttls, t.index)
+		delete(sh.expires, key)
+	}
+}
+
+//expired returns true if key has deadline in the past, shard must be locked at least for reading
+func (sh *shard) expired(key string, currentTime int64) bool {
+	t, ok := sh.expires[key]
+	return ok && currentTime >= t.at
+}
+
+//removeExpired removes keys with passed deadlines, shard must be locked for writing
+//Cost is proportional to number of expired keys
+func (sh *shard) removeExpired(currentTime int64) {
+	for len(sh.ttls) > 0 && currentTime >= sh.ttls[0].at {
+		t := heap.Pop(&sh.ttls).(*ttl)
+		delete(sh.expires, t.key)
+		if _, ok := sh.data[t.key]; ok {
+			sh.drop(t.key)
+			sh.notify(EventExpire, t.key, 0)
+		}
+	}
+}
+
+//expiresWorker removes expired keys
+func (s *Store) expiresWorker() {
+	defer s.workers.Done()
+	ticker := time.NewTicker(expiresInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		currentTime := unixMilli(s.now())
+		for _, sh := range s.shards {
+			sh.Lock()
+			sh.removeExpired(currentTime)
+			sh.Unlock()
+		}
+	}
+}
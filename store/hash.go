@@ -0,0 +1,218 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+//ErrHashValueMissing - hash set is called without fields
+var ErrHashValueMissing = errors.New("ERR at least one field is required")
+
+//Stored hashes are modified in place under shard lock (same as sets), Get returns copy of hash
+
+//fieldSize - approximate memory used by field of hash with its value
+func fieldSize(field string, value interface{}) int64 {
+	return int64(len(field)) + 16 + sizeOf(value)
+}
+
+//lookupHash returns hash stored by key, shard must be locked
+//Returns ErrWrongType if key holds value of other type
+func (sh *shard) lookupHash(key string, currentTime int64) (map[string]interface{}, *item, error) {
+	it, ok := sh.lookup(key, currentTime)
+	if !ok {
+		return nil, nil, nil
+	}
+	h, ok := it.value.(map[string]interface{})
+	if it.typ != TypeHash || !ok {
+		return nil, nil, ErrWrongType
+	}
+	return h, it, nil
+}
+
+//setHashFields sets fields of hash, creates hash if key does not exist, returns number of added fields
+//Shard must be locked for writing
+func (sh *shard) setHashFields(key string, fields map[string]string, currentTime int64) (int, error) {
+	h, it, err := sh.lookupHash(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	created := it == nil
+	if created {
+		h = map[string]interface{}{}
+		it = sh.set(key, h, TypeHash, currentTime)
+	}
+	added := 0
+	for field, value := range fields {
+		if old, ok := h[field]; ok {
+			sh.resize(it, sizeOf(value)-sizeOf(old))
+		} else {
+			sh.resize(it, fieldSize(field, value))
+			added++
+		}
+		h[field] = value
+	}
+	if !created {
+		sh.modified(key, it)
+	}
+	return added, nil
+}
+
+//removeHashFields removes fields of hash, returns number of removed fields. Empty hash is removed
+//Shard must be locked for writing
+func (sh *shard) removeHashFields(key string, fields []string, currentTime int64) (int, error) {
+	h, it, err := sh.lookupHash(key, currentTime)
+	if err != nil || it == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if value, ok := h[field]; ok {
+			delete(h, field)
+			sh.resize(it, -fieldSize(field, value))
+			removed++
+		}
+	}
+	if len(h) == 0 {
+		sh.remove(key)
+	} else if removed > 0 {
+		sh.modified(key, it)
+	}
+	return removed, nil
+}
+
+//HSet sets fields of hash, creates hash if key does not exist, returns number of added fields
+func (s *Store) HSet(key string, fields map[string]string) (int, error) {
+	if len(fields) == 0 {
+		return 0, ErrHashValueMissing
+	}
+	size := int64(0)
+	for field, value := range fields {
+		size += fieldSize(field, value)
+	}
+	if err := s.grow(key, size); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	added, err := sh.setHashFields(key, fields, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "hset", Key: key, Value: fields, Time: currentTime})
+	return added, nil
+}
+
+//HGet returns value of field, returns false if key or field does not exist
+func (s *Store) HGet(key string, field string) (interface{}, bool, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	h, _, err := sh.lookupHash(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := h[field]
+	return value, ok, nil
+}
+
+//HDel removes fields of hash, returns number of removed fields. Empty hash is removed
+func (s *Store) HDel(key string, fields ...string) (int, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	removed, err := sh.removeHashFields(key, fields, currentTime)
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "hdel", Key: key, Value: fields, Time: currentTime})
+	return removed, nil
+}
+
+//HExists returns true if hash has field
+func (s *Store) HExists(key string, field string) (bool, error) {
+	_, ok, err := s.HGet(key, field)
+	return ok, err
+}
+
+//HIncrBy atomically adds delta to integer value of field and returns new value
+//Missing hash or field is created with value 0 before increment
+func (s *Store) HIncrBy(key string, field string, delta int64) (int64, error) {
+	if err := s.grow(key, int64(len(field))+48); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	h, _, err := sh.lookupHash(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if v, ok := h[field]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return 0, ErrNotInteger
+		}
+		if current, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+		return 0, ErrOverflow
+	}
+	current += delta
+	fields := map[string]string{field: strconv.FormatInt(current, 10)}
+	if _, err := sh.setHashFields(key, fields, currentTime); err != nil {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "hset", Key: key, Value: fields, Time: currentTime})
+	return current, nil
+}
+
+//HGetAll returns copy of all fields and values of hash, empty map if key does not exist
+func (s *Store) HGetAll(key string) (map[string]interface{}, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	h, _, err := sh.lookupHash(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, len(h))
+	for field, value := range h {
+		result[field] = value
+	}
+	return result, nil
+}
+
+//HKeys returns sorted names of fields of hash
+func (s *Store) HKeys(key string) ([]string, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	h, _, err := sh.lookupHash(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(h))
+	for field := range h {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//HLen returns number of fields of hash, 0 if key does not exist
+func (s *Store) HLen(key string) (int, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	h, _, err := sh.lookupHash(key, unixMilli(s.now()))
+	return len(h), err
+}
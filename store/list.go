@@ -0,0 +1,533 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Errors of list operations
+var (
+	ErrNoSuchKey        = errors.New("ERR no such key")
+	ErrIndexOutOfRange  = errors.New("ERR index out of range")
+	ErrPivotNotFound    = errors.New("ERR pivot not found")
+	ErrListValueMissing = errors.New("ERR at least one value is required")
+)
+
+//listValue - stored list, deque in ring buffer modified in place under shard lock
+//Get returns copy of items, so stored list is never shared with readers
+type listValue struct {
+	items []interface{}
+	head  int
+	n     int
+}
+
+//minListCapacity - capacity of ring buffer below which list is not shrunk
+const minListCapacity = 16
+
+func newListValue(items []interface{}) *listValue {
+	v := &listValue{items: make([]interface{}, len(items)), n: len(items)}
+	copy(v.items, items)
+	return v
+}
+
+func (v *listValue) len() int {
+	return v.n
+}
+
+//at returns item at position 0 <= i < len
+func (v *listValue) at(i int) interface{} {
+	return v.items[(v.head+i)%len(v.items)]
+}
+
+//put replaces item at position 0 <= i < len
+func (v *listValue) put(i int, value interface{}) {
+	v.items[(v.head+i)%len(v.items)] = value
+}
+
+//slice returns copy of items from start to stop exclusive
+func (v *listValue) slice(start int, stop int) []interface{} {
+	result := make([]interface{}, stop-start)
+	for i := range result {
+		result[i] = v.at(start + i)
+	}
+	return result
+}
+
+//values returns copy of all items
+func (v *listValue) values() []interface{} {
+	return v.slice(0, v.n)
+}
+
+//resize moves items into ring buffer of capacity, the first item gets position 0
+func (v *listValue) resize(capacity int) {
+	items := make([]interface{}, capacity)
+	for i := 0; i < v.n; i++ {
+		items[i] = v.at(i)
+	}
+	v.items, v.head = items, 0
+}
+
+func (v *listValue) pushBack(value interface{}) {
+	if v.n == len(v.items) {
+		v.resize(2*v.n + minListCapacity/4)
+	}
+	v.items[(v.head+v.n)%len(v.items)] = value
+	v.n++
+}
+
+func (v *listValue) pushFront(value interface{}) {
+	if v.n == len(v.items) {
+		v.resize(2*v.n + minListCapacity/4)
+	}
+	v.head = (v.head - 1 + len(v.items)) % len(v.items)
+	v.items[v.head] = value
+	v.n++
+}
+
+func (v *listValue) popFront() interface{} {
+	value := v.items[v.head]
+	v.items[v.head] = nil
+	v.head = (v.head + 1) % len(v.items)
+	v.n--
+	v.shrink()
+	return value
+}
+
+func (v *listValue) popBack() interface{} {
+	i := (v.head + v.n - 1) % len(v.items)
+	value := v.items[i]
+	v.items[i] = nil
+	v.n--
+	v.shrink()
+	return value
+}
+
+//shrink releases memory of ring buffer which is mostly empty
+func (v *listValue) shrink() {
+	if len(v.items) > minListCapacity && v.n < len(v.items)/4 {
+		v.resize(len(v.items) / 2)
+	}
+}
+
+//replace sets all items of list, items are owned by list
+func (v *listValue) replace(items []interface{}) {
+	v.items, v.head, v.n = items, 0, len(items)
+}
+
+//listOf converts list value of Set, dump or AOF into items
+func listOf(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, nil
+	case []string:
+		return stringValues(v), nil
+	default:
+		return nil, fmt.Errorf("list must be array, got %T", value)
+	}
+}
+
+//lookupList returns list stored by key, shard must be locked
+//Returns ErrWrongType if key holds value of other type
+func (sh *shard) lookupList(key string, currentTime int64) (*listValue, *item, error) {
+	it, ok := sh.lookup(key, currentTime)
+	if !ok {
+		return nil, nil, nil
+	}
+	v, ok := it.value.(*listValue)
+	if it.typ != TypeList || !ok {
+		return nil, nil, ErrWrongType
+	}
+	return v, it, nil
+}
+
+//changedList updates size and version of list modified in place, empty list is removed
+//Shard must be locked for writing
+func (sh *shard) changedList(key string, it *item, v *listValue, delta int64) {
+	if v.len() == 0 {
+		sh.remove(key)
+		return
+	}
+	sh.resize(it, delta)
+	sh.modified(key, it)
+}
+
+//listIndex converts index (negative counts from the end) into position in list of length n
+func listIndex(index int, n int) int {
+	if index < 0 {
+		index += n
+	}
+	return index
+}
+
+//listRange converts inclusive start and stop (negative count from the end) into bounds of slice
+//Returns false if range is empty
+func listRange(start int, stop int, n int) (int, int, bool) {
+	start, stop = listIndex(start, n), listIndex(stop, n)
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+//stringValues converts strings into list items
+func stringValues(values []string) []interface{} {
+	l := make([]interface{}, len(values))
+	for i, v := range values {
+		l[i] = v
+	}
+	return l
+}
+
+//pushList adds values to the head one after another (last value becomes first) or to the tail of list,
+//creates list if key does not exist. Returns new length, shard must be locked for writing
+func (sh *shard) pushList(key string, values []string, head bool, currentTime int64) (int, error) {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	created := it == nil
+	if created {
+		v = newListValue(nil)
+		it = sh.set(key, v, TypeList, currentTime)
+	}
+	size := int64(0)
+	for _, value := range values {
+		if head {
+			v.pushFront(value)
+		} else {
+			v.pushBack(value)
+		}
+		size += sizeOf(value)
+	}
+	if created {
+		sh.resize(it, size)
+	} else {
+		sh.changedList(key, it, v, size)
+	}
+	return v.len(), nil
+}
+
+//push adds values to the head or to the tail of list, creates list if key does not exist
+func (s *Store) push(key string, values []string, head bool) (int, error) {
+	if len(values) == 0 {
+		return 0, ErrListValueMissing
+	}
+	if err := s.grow(key, sizeOf(stringValues(values))); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	n, err := sh.pushList(key, values, head, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	op := "rpush"
+	if head {
+		op = "lpush"
+	}
+	s.appendAOF(&aofRecord{Op: op, Key: key, Value: values, Time: currentTime})
+	return n, nil
+}
+
+//LPush inserts values at the head of list one after another (last value becomes first), returns new length
+func (s *Store) LPush(key string, values ...string) (int, error) {
+	return s.push(key, values, true)
+}
+
+//RPush appends values to the tail of list, returns new length
+func (s *Store) RPush(key string, values ...string) (int, error) {
+	return s.push(key, values, false)
+}
+
+//popList removes and returns first (head) or last item of list, empty list is removed
+//Shard must be locked for writing
+func (sh *shard) popList(key string, head bool, currentTime int64) (interface{}, bool, error) {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil || it == nil || v.len() == 0 {
+		return nil, false, err
+	}
+	var value interface{}
+	if head {
+		value = v.popFront()
+	} else {
+		value = v.popBack()
+	}
+	sh.changedList(key, it, v, -sizeOf(value))
+	return value, true, nil
+}
+
+//pop removes and returns first or last item of list, returns false if list does not exist
+func (s *Store) pop(key string, head bool) (interface{}, bool, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	return s.popLocked(sh, key, head, unixMilli(s.now()))
+}
+
+//popLocked removes and returns first (head) or last item of list, shard must be locked for writing
+func (s *Store) popLocked(sh *shard, key string, head bool, currentTime int64) (interface{}, bool, error) {
+	value, ok, err := sh.popList(key, head, currentTime)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	op := "rpop"
+	if head {
+		op = "lpop"
+	}
+	s.appendAOF(&aofRecord{Op: op, Key: key, Time: currentTime})
+	return value, true, nil
+}
+
+//LPop removes and returns first item of list, returns false if list does not exist
+func (s *Store) LPop(key string) (interface{}, bool, error) {
+	return s.pop(key, true)
+}
+
+//RPop removes and returns last item of list, returns false if list does not exist
+func (s *Store) RPop(key string) (interface{}, bool, error) {
+	return s.pop(key, false)
+}
+
+//LRange returns items from start to stop inclusive, negative index counts from the end (-1 is the last item)
+func (s *Store) LRange(key string, start int, stop int) ([]interface{}, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, it, err := sh.lookupList(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	if it == nil {
+		return []interface{}{}, nil
+	}
+	if start, stop, ok := listRange(start, stop, v.len()); ok {
+		return v.slice(start, stop), nil
+	}
+	return []interface{}{}, nil
+}
+
+//LIndex returns item at index (negative counts from the end), returns false if key does not exist or index is out of range
+func (s *Store) LIndex(key string, index int) (interface{}, bool, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, it, err := sh.lookupList(key, unixMilli(s.now()))
+	if err != nil || it == nil {
+		return nil, false, err
+	}
+	index = listIndex(index, v.len())
+	if index < 0 || index >= v.len() {
+		return nil, false, nil
+	}
+	return v.at(index), true, nil
+}
+
+//trimList keeps only items from start to stop inclusive, list is removed if range is empty
+//Shard must be locked for writing
+func (sh *shard) trimList(key string, start int, stop int, currentTime int64) error {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil || it == nil {
+		return err
+	}
+	start, stop, ok := listRange(start, stop, v.len())
+	if !ok {
+		sh.remove(key)
+		return nil
+	}
+	if start == 0 && stop == v.len() {
+		return nil
+	}
+	size := int64(0)
+	for i := 0; i < v.len(); i++ {
+		if i < start || i >= stop {
+			size -= sizeOf(v.at(i))
+		}
+	}
+	v.replace(v.slice(start, stop))
+	sh.changedList(key, it, v, size)
+	return nil
+}
+
+//LTrim keeps only items from start to stop inclusive, list is removed if range is empty
+func (s *Store) LTrim(key string, start int, stop int) error {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	if err := sh.trimList(key, start, stop, currentTime); err != nil {
+		return err
+	}
+	s.appendAOF(&aofRecord{Op: "ltrim", Key: key, Index: start, Stop: stop, Time: currentTime})
+	return nil
+}
+
+//setListItem replaces item at index (negative counts from the end), shard must be locked for writing
+//Returns ErrNoSuchKey or ErrIndexOutOfRange
+func (sh *shard) setListItem(key string, index int, value string, currentTime int64) error {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return ErrNoSuchKey
+	}
+	index = listIndex(index, v.len())
+	if index < 0 || index >= v.len() {
+		return ErrIndexOutOfRange
+	}
+	size := sizeOf(value) - sizeOf(v.at(index))
+	v.put(index, value)
+	sh.changedList(key, it, v, size)
+	return nil
+}
+
+//LSet replaces item at index, returns ErrNoSuchKey or ErrIndexOutOfRange
+func (s *Store) LSet(key string, index int, value string) error {
+	if err := s.grow(key, sizeOf(value)); err != nil {
+		return err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	if err := sh.setListItem(key, index, value, currentTime); err != nil {
+		return err
+	}
+	s.appendAOF(&aofRecord{Op: "lset", Key: key, Value: value, Index: index, Time: currentTime})
+	return nil
+}
+
+//removeListItems removes items equal to value (see LRem), returns number of removed items
+//Shard must be locked for writing
+func (sh *shard) removeListItems(key string, count int, value string, currentTime int64) (int, error) {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil || it == nil {
+		return 0, err
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := make([]bool, v.len())
+	n := 0
+	for i := 0; i < v.len(); i++ {
+		j := i
+		if count < 0 {
+			j = v.len() - 1 - i
+		}
+		if item, ok := v.at(j).(string); ok && item == value {
+			removed[j] = true
+			n++
+			if n == limit {
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	result := make([]interface{}, 0, v.len()-n)
+	for i := 0; i < v.len(); i++ {
+		if !removed[i] {
+			result = append(result, v.at(i))
+		}
+	}
+	v.replace(result)
+	sh.changedList(key, it, v, -int64(n)*sizeOf(value))
+	return n, nil
+}
+
+//LRem removes items equal to value: count > 0 - first count items from the head,
+//count < 0 - first |count| items from the tail, count = 0 - all items. Returns number of removed items
+func (s *Store) LRem(key string, count int, value string) (int, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	n, err := sh.removeListItems(key, count, value, currentTime)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "lrem", Key: key, Value: value, Count: count, Time: currentTime})
+	return n, nil
+}
+
+//LLen returns length of list, 0 if key does not exist
+func (s *Store) LLen(key string) (int, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, it, err := sh.lookupList(key, unixMilli(s.now()))
+	if err != nil || it == nil {
+		return 0, err
+	}
+	return v.len(), nil
+}
+
+//insertListItem inserts value at position 0 <= index <= length of list, returns new length
+//Returns ErrNoSuchKey if list does not exist, shard must be locked for writing
+func (sh *shard) insertListItem(key string, index int, value string, currentTime int64) (int, error) {
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	if it == nil {
+		return 0, ErrNoSuchKey
+	}
+	if index < 0 || index > v.len() {
+		return 0, ErrIndexOutOfRange
+	}
+	result := make([]interface{}, 0, v.len()+1)
+	result = append(result, v.slice(0, index)...)
+	result = append(result, value)
+	result = append(result, v.slice(index, v.len())...)
+	v.replace(result)
+	sh.changedList(key, it, v, sizeOf(value))
+	return v.len(), nil
+}
+
+//LInsert inserts value before or after first item equal to pivot, returns new length
+//Returns ErrNoSuchKey if list does not exist and ErrPivotNotFound if there is no pivot in list
+func (s *Store) LInsert(key string, before bool, pivot string, value string) (int, error) {
+	if err := s.grow(key, sizeOf(value)); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	v, it, err := sh.lookupList(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	if it == nil {
+		return 0, ErrNoSuchKey
+	}
+	index := -1
+	for i := 0; i < v.len(); i++ {
+		if item, ok := v.at(i).(string); ok && item == pivot {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, ErrPivotNotFound
+	}
+	if !before {
+		index++
+	}
+	n, err := sh.insertListItem(key, index, value, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "linsert", Key: key, Value: value, Index: index, Time: currentTime})
+	return n, nil
+}
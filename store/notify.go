@@ -0,0 +1,192 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//Kinds of key events
+const (
+	EventSet    = "set"
+	EventRemove = "remove"
+	EventExpire = "expire"
+	EventEvict  = "evict"
+)
+
+//DefaultEventBuffer - number of events buffered for subscriber if buffer size is not set
+const DefaultEventBuffer = 256
+
+//Event - change of key: value is set (Version is new version of key), key is removed, expired or evicted
+type Event struct {
+	Kind    string
+	Key     string
+	Version uint64
+}
+
+//Delivery - state of buffered channel of subscriber, shared by subscriptions to key events and by pub/sub subscribers
+//Values are sent without blocking, subscriber which does not read them fast enough is dropped: its channel is closed
+type Delivery struct {
+	mu      sync.Mutex
+	closed  bool
+	dropped bool
+}
+
+//Dropped returns true if channel is closed because its buffer overflowed
+func (d *Delivery) Dropped() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+//Close calls closeChan if channel is not closed yet
+func (d *Delivery) Close(closeChan func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		closeChan()
+	}
+}
+
+//Send calls trySend if channel is not closed, trySend must not block and returns false if buffer is full:
+//then closeChan is called and delivery is dropped. Returns false if channel is closed or dropped
+func (d *Delivery) Send(trySend func() bool, closeChan func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	if trySend() {
+		return true
+	}
+	d.closed, d.dropped = true, true
+	closeChan()
+	return false
+}
+
+//Subscription - stream of events of keys matching pattern
+//Events are buffered, subscriber which does not read events fast enough is dropped: events channel is closed and Dropped returns true
+type Subscription struct {
+	delivery Delivery
+	events   chan Event
+	match    matcher
+	n        *notifier
+}
+
+//Events returns channel of events, channel is closed when subscription is closed or dropped
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+//Dropped returns true if subscription is closed because its buffer overflowed
+func (sub *Subscription) Dropped() bool {
+	return sub.delivery.Dropped()
+}
+
+//Close stops delivery of events and closes events channel
+func (sub *Subscription) Close() {
+	sub.n.remove(sub)
+	sub.close()
+}
+
+func (sub *Subscription) close() {
+	sub.delivery.Close(sub.closeEvents)
+}
+
+func (sub *Subscription) closeEvents() {
+	close(sub.events)
+}
+
+//send delivers event without blocking, returns false if subscription is closed or its buffer is full and it is dropped
+func (sub *Subscription) send(e Event) bool {
+	return sub.delivery.Send(func() bool {
+		select {
+		case sub.events <- e:
+			return true
+		default:
+			return false
+		}
+	}, sub.closeEvents)
+}
+
+//notifier - subscriptions of store, events are sent under lock of key's shard so events of key are delivered in order
+type notifier struct {
+	sync.RWMutex
+	subs  map[*Subscription]struct{}
+	count int32
+}
+
+//notify sends event to subscriptions matching key and removes dropped ones
+func (n *notifier) notify(e Event) {
+	if atomic.LoadInt32(&n.count) == 0 {
+		return
+	}
+	var dropped []*Subscription
+	n.RLock()
+	for sub := range n.subs {
+		if sub.match != nil && !sub.match.Match(e.Key) {
+			continue
+		}
+		if !sub.send(e) {
+			dropped = append(dropped, sub)
+		}
+	}
+	n.RUnlock()
+	for _, sub := range dropped {
+		n.remove(sub)
+	}
+}
+
+func (n *notifier) add(sub *Subscription) {
+	n.Lock()
+	defer n.Unlock()
+	if n.subs == nil {
+		n.subs = map[*Subscription]struct{}{}
+	}
+	n.subs[sub] = struct{}{}
+	atomic.StoreInt32(&n.count, int32(len(n.subs)))
+}
+
+func (n *notifier) remove(sub *Subscription) {
+	n.Lock()
+	defer n.Unlock()
+	delete(n.subs, sub)
+	atomic.StoreInt32(&n.count, int32(len(n.subs)))
+}
+
+//Subscribe returns subscription to events of keys matching glob pattern (empty pattern matches all keys)
+//buffer is max number of undelivered events (DefaultEventBuffer if buffer <= 0). Returns *PatternError if pattern is malformed
+//Expired keys are reported when they are removed by background worker
+func (s *Store) Subscribe(pattern string, buffer int) (*Subscription, error) {
+	var m matcher
+	if pattern != "" {
+		var err error
+		if m, err = s.patterns.compile(pattern, false); err != nil {
+			return nil, err
+		}
+	}
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	sub := &Subscription{events: make(chan Event, buffer), match: m, n: s.events}
+	s.events.add(sub)
+	return sub, nil
+}
+
+//CloseSubscriptions closes all subscriptions, events channels of subscribers are closed
+func (s *Store) CloseSubscriptions() {
+	s.events.Lock()
+	subs := s.events.subs
+	s.events.subs = nil
+	atomic.StoreInt32(&s.events.count, 0)
+	s.events.Unlock()
+	for sub := range subs {
+		sub.close()
+	}
+}
+
+//notify sends event of key to subscribers and wakes waiters of key, shard must be locked for writing
+func (sh *shard) notify(kind string, key string, version uint64) {
+	sh.wake(key)
+	sh.events.notify(Event{Kind: kind, Key: key, Version: version})
+}
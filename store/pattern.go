@@ -0,0 +1,80 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/gobwas/glob"
+)
+
+//maxPatterns - max number of compiled patterns kept in cache, cache is cleared when it is full
+const maxPatterns = 1024
+
+//PatternError - pattern of keys or of pub/sub channels cannot be compiled
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("ERR invalid pattern %q: %s", e.Pattern, e.Err.Error())
+}
+
+//matcher - compiled glob pattern or regular expression
+type matcher interface {
+	Match(string) bool
+}
+
+//regexpMatcher - regular expression, key matches if any part of it matches (^ and $ anchor the whole key)
+type regexpMatcher struct {
+	*regexp.Regexp
+}
+
+func (m regexpMatcher) Match(key string) bool {
+	return m.MatchString(key)
+}
+
+//patternKey - key of compiled pattern in cache
+type patternKey struct {
+	pattern string
+	regex   bool
+}
+
+//patternCache - cache of compiled patterns, patterns of keys and scans are usually repeated by clients
+type patternCache struct {
+	sync.Mutex
+	compiled map[patternKey]matcher
+}
+
+//compile returns compiled glob pattern (or regular expression if regex is true) from cache
+//Returns *PatternError if pattern is malformed
+func (c *patternCache) compile(pattern string, regex bool) (matcher, error) {
+	k := patternKey{pattern: pattern, regex: regex}
+	c.Lock()
+	m, ok := c.compiled[k]
+	c.Unlock()
+	if ok {
+		return m, nil
+	}
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, &PatternError{Pattern: pattern, Err: err}
+		}
+		m = regexpMatcher{re}
+	} else {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, &PatternError{Pattern: pattern, Err: err}
+		}
+		m = g
+	}
+	c.Lock()
+	if c.compiled == nil || len(c.compiled) >= maxPatterns {
+		c.compiled = map[patternKey]matcher{}
+	}
+	c.compiled[k] = m
+	c.Unlock()
+	return m, nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+//Recovery modes for corrupt or unreadable dump and AOF files at startup
+//RecoveryStrict (default) refuses to start, RecoveryQuarantine moves bad file aside and starts empty,
+//RecoveryPartial loads all valid records
+const (
+	RecoveryStrict     = "strict"
+	RecoveryQuarantine = "quarantine"
+	RecoveryPartial    = "partial"
+)
+
+//LoadReport - result of loading data at startup
+type LoadReport struct {
+	File        string
+	Format      string
+	Keys        int
+	Skipped     int
+	Quarantined string
+	Error       string
+}
+
+//String returns human-readable report
+func (r *LoadReport) String() string {
+	if r.File == "" {
+		return "no data loaded, starting empty"
+	}
+	msg := fmt.Sprintf("loaded %d keys from %s (%s)", r.Keys, r.File, r.Format)
+	if r.Skipped > 0 {
+		msg += fmt.Sprintf(", %d corrupt records skipped", r.Skipped)
+	}
+	if r.Quarantined != "" {
+		msg += fmt.Sprintf(", corrupt file moved to %s", r.Quarantined)
+	}
+	if r.Error != "" {
+		msg += fmt.Sprintf(", error: %s", r.Error)
+	}
+	return msg
+}
+
+//recover handles error of reading file according to recovery mode
+//Returns error if store must not start
+func (s *Store) recover(report *LoadReport, err error) error {
+	report.Error = err.Error()
+	switch s.Recovery {
+	case RecoveryPartial:
+		return nil
+	case RecoveryQuarantine:
+		s.reset()
+		report.Keys = 0
+		quarantined := fmt.Sprintf("%s.corrupt-%d", report.File, time.Now().Unix())
+		if err := os.Rename(report.File, quarantined); err != nil {
+			return fmt.Errorf("cannot quarantine %s: %s", report.File, err.Error())
+		}
+		report.Quarantined = quarantined
+		return nil
+	default:
+		return fmt.Errorf("cannot load %s: %s", report.File, err.Error())
+	}
+}
+
+//reset removes all keys
+func (s *Store) reset() {
+	for _, sh := range s.shards {
+		sh.Lock()
+		for key := range sh.data {
+			sh.remove(key)
+		}
+		sh.Unlock()
+	}
+}
+
+//LoadReport returns report about data loaded at startup
+func (s *Store) LoadReport() LoadReport {
+	return s.report
+}
+
+//logReport prints report of loading data
+func logReport(report *LoadReport) {
+	log.Printf("Storage recovery: %s\n", report.String())
+}
+
+//readJSONPartial decodes legacy JSON dump entry by entry and returns all entries read before first error
+func readJSONPartial(r io.Reader) (*dump, int, error) {
+	d := &dump{
+		Data:     map[string]interface{}{},
+		Types:    map[string]string{},
+		Expires:  map[string]int64{},
+		PExpires: map[string]int64{},
+	}
+	skipped := 0
+	dec := json.NewDecoder(r)
+	err := readJSONObject(dec, func(field string) error {
+		switch field {
+		case "data":
+			return readJSONObject(dec, func(key string) error {
+				var value interface{}
+				if err := dec.Decode(&value); err != nil {
+					return err
+				}
+				d.Data[key] = value
+				return nil
+			})
+		case "types":
+			return readJSONObject(dec, func(key string) error {
+				var value interface{}
+				if err := dec.Decode(&value); err != nil {
+					return err
+				}
+				if typ, ok := value.(string); ok {
+					d.Types[key] = typ
+				}
+				return nil
+			})
+		case "expires", "pexpires":
+			return readJSONObject(dec, func(key string) error {
+				var value interface{}
+				if err := dec.Decode(&value); err != nil {
+					return err
+				}
+				at, ok := value.(float64)
+				if !ok {
+					skipped++
+					return nil
+				}
+				if field == "expires" {
+					d.Expires[key] = int64(at)
+				} else {
+					d.PExpires[key] = int64(at)
+				}
+				return nil
+			})
+		default:
+			var value interface{}
+			return dec.Decode(&value)
+		}
+	})
+	return d, skipped, err
+}
+
+//readJSONObject reads object from decoder calling fn for each field, fn must consume field's value
+func readJSONObject(dec *json.Decoder, fn func(string) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected object, got %v", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected object key, got %v", t)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,100 @@
+package store
+
+import "errors"
+
+//ErrSameKey - key is copied into itself
+var ErrSameKey = errors.New("ERR source and destination keys are the same")
+
+//copyValue returns copy of stored value which may be stored by another key
+//Strings and bytes are never modified in place, so they are shared
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return cloneValue(v)
+	case *listValue:
+		return newListValue(v.values())
+	case setValue:
+		return newSetValue(v.members())
+	case *zsetValue:
+		return newZSetValue(v.members())
+	default:
+		return value
+	}
+}
+
+//Rename moves value of src with its type and expiration time to dst, existing dst is overwritten
+//Returns ErrNoSuchKey if src does not exist
+func (s *Store) Rename(src string, dst string) error {
+	_, err := s.transfer(src, dst, true, true)
+	return err
+}
+
+//RenameNX moves value of src with its type and expiration time to dst, returns false if dst exists
+//Returns ErrNoSuchKey if src does not exist
+func (s *Store) RenameNX(src string, dst string) (bool, error) {
+	return s.transfer(src, dst, true, false)
+}
+
+//Copy copies value of src with its type and expiration time to dst, returns false if dst exists and replace is false
+//Returns ErrNoSuchKey if src does not exist and ErrSameKey if src and dst are the same key
+func (s *Store) Copy(src string, dst string, replace bool) (bool, error) {
+	if src == dst {
+		return false, ErrSameKey
+	}
+	sh := s.shard(src)
+	sh.RLock()
+	size := int64(0)
+	if it, ok := sh.lookup(src, unixMilli(s.now())); ok {
+		size = it.size - int64(len(src)) + int64(len(dst))
+	}
+	sh.RUnlock()
+	if s.limited() && size > 0 {
+		if err := s.reserveSize(dst, size); err != nil {
+			return false, err
+		}
+	}
+	return s.transfer(src, dst, false, replace)
+}
+
+//transfer moves or copies src to dst with shards of both keys locked, so the operation is atomic
+//New value of dst is logged with its deadline (or persist if src has no expiration time) as single transaction
+func (s *Store) transfer(src string, dst string, move bool, replace bool) (bool, error) {
+	unlock := s.lockKeys([]string{src, dst})
+	defer unlock()
+	currentTime := unixMilli(s.now())
+	from, to := s.shard(src), s.shard(dst)
+	it, ok := from.lookup(src, currentTime)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	if _, ok := to.lookup(dst, currentTime); ok && !replace {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+	at := int64(0)
+	if t, ok := from.expires[src]; ok {
+		at = t.at
+	}
+	value := it.value
+	if !move {
+		value = copyValue(value)
+	}
+	to.set(dst, value, it.typ, currentTime)
+	tx := &aofRecord{Op: "tx", Time: currentTime, Ops: []*aofRecord{
+		{Op: "set", Key: dst, Value: cloneValue(value), Type: recordType(it.typ), At: at},
+	}}
+	if at > 0 {
+		to.setExpires(dst, at)
+	} else {
+		to.removeExpires(dst)
+		tx.Ops = append(tx.Ops, &aofRecord{Op: "persist", Key: dst})
+	}
+	if move {
+		from.remove(src)
+		tx.Ops = append(tx.Ops, &aofRecord{Op: "remove", Key: src})
+	}
+	s.appendAOF(tx)
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//DefaultScanCount - number of keys returned by Scan if count is not set
+const DefaultScanCount = 10
+
+//Errors of scan
+var (
+	ErrInvalidCursor = errors.New("ERR invalid cursor")
+	ErrUnknownType   = errors.New("ERR unknown type")
+)
+
+//scanCursor - position of scan: shard and the last key returned from it (started is false if no key is returned yet)
+type scanCursor struct {
+	shard   int
+	after   string
+	started bool
+}
+
+//String encodes cursor into opaque string, "0" is the end of scan
+func (c scanCursor) String() string {
+	v := strconv.Itoa(c.shard)
+	if c.started {
+		v += ":" + c.after
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(v))
+}
+
+//parseCursor decodes cursor returned by Scan, empty string or "0" starts new scan
+func parseCursor(cursor string, shards int) (scanCursor, error) {
+	c := scanCursor{}
+	if cursor == "" || cursor == "0" {
+		return c, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	v := string(b)
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		v, c.after, c.started = v[:i], v[i+1:], true
+	}
+	c.shard, err = strconv.Atoi(v)
+	if err != nil || c.shard < 0 || c.shard >= shards {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+//keyHeap - max-heap of keys, implements heap.Interface
+type keyHeap []string
+
+func (h keyHeap) Len() int { return len(h) }
+
+func (h keyHeap) Less(i, j int) bool { return h[i] > h[j] }
+
+func (h keyHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	key := old[n-1]
+	*h = old[:n-1]
+	return key
+}
+
+//scan returns up to n smallest keys greater than cursor key which match pattern (nil matches all) and type (empty - any type)
+//Only n keys are kept while shard is iterated: the greatest of them is replaced by smaller matching key
+func (sh *shard) scan(c scanCursor, m matcher, typ string, n int, currentTime int64) []string {
+	sh.RLock()
+	defer sh.RUnlock()
+	size := n
+	if size > len(sh.data) {
+		size = len(sh.data)
+	}
+	keys := make(keyHeap, 0, size)
+	for key, it := range sh.data {
+		if c.started && key <= c.after || len(keys) == n && key >= keys[0] || sh.expired(key, currentTime) {
+			continue
+		}
+		if m != nil && !m.Match(key) || typ != "" && it.typ != typ {
+			continue
+		}
+		if len(keys) < n {
+			heap.Push(&keys, key)
+			continue
+		}
+		keys[0] = key
+		heap.Fix(&keys, 0)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Scan iterates keys incrementally: returns up to count keys matching glob pattern (empty matches all) and type (empty - any type)
+//and cursor for the next call. Iteration starts with empty cursor or "0" and is finished when returned cursor is "0"
+//Returns *PatternError if pattern is malformed
+//Keys are visited shard by shard in lexicographic order, so key present during the whole iteration is returned exactly once
+//regardless of concurrent writes, keys added or removed during iteration may be returned or not
+func (s *Store) Scan(cursor string, pattern string, typ string, count int) ([]string, string, error) {
+	c, err := parseCursor(cursor, len(s.shards))
+	if err != nil {
+		return nil, "", err
+	}
+	var m matcher
+	if pattern != "" {
+		if m, err = s.patterns.compile(pattern, false); err != nil {
+			return nil, "", err
+		}
+	}
+	if typ != "" && !validType(typ) {
+		return nil, "", ErrUnknownType
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	keys := []string{}
+	currentTime := unixMilli(s.now())
+	for c.shard < len(s.shards) {
+		n := count - len(keys)
+		found := s.shards[c.shard].scan(c, m, typ, n, currentTime)
+		keys = append(keys, found...)
+		if len(found) < n {
+			c = scanCursor{shard: c.shard + 1}
+			continue
+		}
+		c.after, c.started = found[len(found)-1], true
+		return keys, c.String(), nil
+	}
+	return keys, "0", nil
+}
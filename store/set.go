@@ -0,0 +1,250 @@
+package store
+
+import (
+	"errors"
+	"sort"
+)
+
+//ErrSetValueMissing - set operation is called without members
+var ErrSetValueMissing = errors.New("ERR at least one member is required")
+
+//setValue - stored set, modified in place under shard lock
+type setValue map[string]struct{}
+
+//Set - sorted members of set returned by Get and written into dumps
+type Set []string
+
+func newSetValue(members []string) setValue {
+	v := make(setValue, len(members))
+	for _, member := range members {
+		v[member] = struct{}{}
+	}
+	return v
+}
+
+//members returns sorted copy of members
+func (v setValue) members() Set {
+	members := make(Set, 0, len(v))
+	for member := range v {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+//memberSize - approximate memory used by member of set
+func memberSize(member string) int64 {
+	return int64(len(member)) + 16
+}
+
+//resize changes size of item modified in place, shard must be locked for writing
+func (sh *shard) resize(it *item, delta int64) {
+	it.size += delta
+	sh.mem.add(delta, 0)
+}
+
+//lookupSet returns set stored by key, shard must be locked
+//Returns ErrWrongType if key holds value of other type
+func (sh *shard) lookupSet(key string, currentTime int64) (setValue, *item, error) {
+	it, ok := sh.lookup(key, currentTime)
+	if !ok {
+		return nil, nil, nil
+	}
+	v, ok := it.value.(setValue)
+	if it.typ != TypeSet || !ok {
+		return nil, nil, ErrWrongType
+	}
+	return v, it, nil
+}
+
+//addSetMembers adds members to set, creates set if key does not exist, returns added members
+//Shard must be locked for writing
+func (sh *shard) addSetMembers(key string, members []string, currentTime int64) ([]string, error) {
+	v, it, err := sh.lookupSet(key, currentTime)
+	if err != nil {
+		return nil, err
+	}
+	created := it == nil
+	if created {
+		v = setValue{}
+		it = sh.set(key, v, TypeSet, currentTime)
+	}
+	added := []string{}
+	for _, member := range members {
+		if _, ok := v[member]; !ok {
+			v[member] = struct{}{}
+			sh.resize(it, memberSize(member))
+			added = append(added, member)
+		}
+	}
+	if len(v) == 0 {
+		sh.remove(key)
+	} else if len(added) > 0 && !created {
+		sh.modified(key, it)
+	}
+	return added, nil
+}
+
+//removeSetMembers removes members from set, returns removed members. Empty set is removed
+//Shard must be locked for writing
+func (sh *shard) removeSetMembers(key string, members []string, currentTime int64) ([]string, error) {
+	v, it, err := sh.lookupSet(key, currentTime)
+	if err != nil || it == nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, member := range members {
+		if _, ok := v[member]; ok {
+			delete(v, member)
+			sh.resize(it, -memberSize(member))
+			removed = append(removed, member)
+		}
+	}
+	if len(v) == 0 {
+		sh.remove(key)
+	} else if len(removed) > 0 {
+		sh.modified(key, it)
+	}
+	return removed, nil
+}
+
+//SAdd adds members to set, creates set if key does not exist. Returns number of added members
+func (s *Store) SAdd(key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, ErrSetValueMissing
+	}
+	size := int64(0)
+	for _, member := range members {
+		size += memberSize(member)
+	}
+	if err := s.grow(key, size); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	added, err := sh.addSetMembers(key, members, currentTime)
+	if err != nil || len(added) == 0 {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "sadd", Key: key, Value: added, Time: currentTime})
+	return len(added), nil
+}
+
+//SRem removes members from set, returns number of removed members. Empty set is removed
+func (s *Store) SRem(key string, members ...string) (int, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	removed, err := sh.removeSetMembers(key, members, currentTime)
+	if err != nil || len(removed) == 0 {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "srem", Key: key, Value: removed, Time: currentTime})
+	return len(removed), nil
+}
+
+//SIsMember returns true if member is in set
+func (s *Store) SIsMember(key string, member string) (bool, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupSet(key, unixMilli(s.now()))
+	_, ok := v[member]
+	return ok, err
+}
+
+//SMembers returns sorted members of set, empty if key does not exist
+func (s *Store) SMembers(key string) (Set, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupSet(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	return v.members(), nil
+}
+
+//SCard returns number of members of set, 0 if key does not exist
+func (s *Store) SCard(key string) (int, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupSet(key, unixMilli(s.now()))
+	return len(v), err
+}
+
+//combine reads sets of keys at the same moment (missing keys are empty sets) and returns sorted members of fn result
+func (s *Store) combine(keys []string, fn func([]setValue) setValue) (Set, error) {
+	if len(keys) == 0 {
+		return nil, ErrSetValueMissing
+	}
+	unlock := s.rlockKeys(keys)
+	defer unlock()
+	currentTime := unixMilli(s.now())
+	sets := make([]setValue, len(keys))
+	for i, key := range keys {
+		v, _, err := s.shard(key).lookupSet(key, currentTime)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = v
+	}
+	return fn(sets).members(), nil
+}
+
+//SInter returns members present in all sets
+func (s *Store) SInter(keys ...string) (Set, error) {
+	return s.combine(keys, func(sets []setValue) setValue {
+		smallest := sets[0]
+		for _, v := range sets {
+			if len(v) < len(smallest) {
+				smallest = v
+			}
+		}
+		result := setValue{}
+	next:
+		for member := range smallest {
+			for _, v := range sets {
+				if _, ok := v[member]; !ok {
+					continue next
+				}
+			}
+			result[member] = struct{}{}
+		}
+		return result
+	})
+}
+
+//SUnion returns members present in any of sets
+func (s *Store) SUnion(keys ...string) (Set, error) {
+	return s.combine(keys, func(sets []setValue) setValue {
+		result := setValue{}
+		for _, v := range sets {
+			for member := range v {
+				result[member] = struct{}{}
+			}
+		}
+		return result
+	})
+}
+
+//SDiff returns members of the first set which are not present in other sets
+func (s *Store) SDiff(keys ...string) (Set, error) {
+	return s.combine(keys, func(sets []setValue) setValue {
+		result := setValue{}
+	next:
+		for member := range sets[0] {
+			for _, v := range sets[1:] {
+				if _, ok := v[member]; ok {
+					continue next
+				}
+			}
+			result[member] = struct{}{}
+		}
+		return result
+	})
+}
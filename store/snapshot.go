@@ -0,0 +1,503 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+//Dump formats
+const (
+	FormatBinary = "binary"
+	FormatJSON   = "json"
+)
+
+//Dump compression modes (binary format only)
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+//Binary snapshot layout:
+//
+//	header:  magic "KVSNAP" | version uint16 | flags byte
+//	body:    record... | end record (optionally gzip-compressed as a whole)
+//	record:  kind byte | payload length uvarint | payload | crc32 (IEEE) of payload, uint32 little-endian
+//	entry payload: key | deadline varint (unix milliseconds, 0 - no expiration) | value
+//	end payload:   number of entries uvarint
+//
+//Values are tagged, so type of value is restored exactly as it was stored
+const (
+	snapshotMagic   = "KVSNAP"
+	snapshotVersion = 1
+
+	flagGzip byte = 1 << 0
+
+	recordEntry byte = 1
+	recordEnd   byte = 0xff
+
+	//recordChunk - initial buffer of record payload, larger payload grows buffer while it is read
+	recordChunk = 64 << 10
+
+	valueNil    byte = 'n'
+	valueString byte = 's'
+	valueInt    byte = 'i'
+	valueFloat  byte = 'f'
+	valueBool   byte = 'b'
+	valueList   byte = 'l'
+	valueMap    byte = 'm'
+	valueSet    byte = 'S'
+	valueZSet   byte = 'z'
+	valueCount  byte = 'c'
+	valueBytes  byte = 'B'
+)
+
+//counter - string value of counter key, tagged separately from plain strings in snapshot
+type counter string
+
+//ErrChecksum - snapshot record is corrupted
+var ErrChecksum = errors.New("snapshot record checksum mismatch")
+
+//isBinarySnapshot returns true if data starts with binary snapshot magic
+func isBinarySnapshot(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(snapshotMagic))
+}
+
+//snapshotWriter - encoder of binary snapshot records
+type snapshotWriter struct {
+	w       io.Writer
+	payload []byte
+	buf     [binary.MaxVarintLen64]byte
+}
+
+//writeSnapshot encodes dump in binary format
+func writeSnapshot(w io.Writer, d *dump, compression string) error {
+	header := make([]byte, 0, len(snapshotMagic)+3)
+	header = append(header, snapshotMagic...)
+	header = append(header, byte(snapshotVersion), byte(snapshotVersion>>8))
+	var flags byte
+	if compression == CompressionGzip {
+		flags |= flagGzip
+	}
+	header = append(header, flags)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	var gz *gzip.Writer
+	if flags&flagGzip != 0 {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	sw := &snapshotWriter{w: w}
+	var count uint64
+	for key, value := range d.Data {
+		sw.payload = sw.payload[:0]
+		sw.appendString(key)
+		sw.payload = appendVarint(sw.payload, d.PExpires[key])
+		if str, ok := value.(string); ok && d.Types[key] == TypeCounter {
+			value = counter(str)
+		}
+		if err := sw.appendValue(value); err != nil {
+			return fmt.Errorf("key %s: %s", key, err.Error())
+		}
+		if err := sw.writeRecord(recordEntry); err != nil {
+			return err
+		}
+		count++
+	}
+	sw.payload = appendUvarint(sw.payload[:0], count)
+	if err := sw.writeRecord(recordEnd); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+//writeRecord writes current payload as record of given kind
+func (sw *snapshotWriter) writeRecord(kind byte) error {
+	n := binary.PutUvarint(sw.buf[:], uint64(len(sw.payload)))
+	if _, err := sw.w.Write(append([]byte{kind}, sw.buf[:n]...)); err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(sw.payload); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(sw.payload))
+	_, err := sw.w.Write(sum[:])
+	return err
+}
+
+func (sw *snapshotWriter) appendString(v string) {
+	sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+	sw.payload = append(sw.payload, v...)
+}
+
+//appendValue encodes tagged value
+func (sw *snapshotWriter) appendValue(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		sw.payload = append(sw.payload, valueNil)
+	case string:
+		sw.payload = append(sw.payload, valueString)
+		sw.appendString(v)
+	case counter:
+		sw.payload = append(sw.payload, valueCount)
+		sw.appendString(string(v))
+	case []byte:
+		sw.payload = append(sw.payload, valueBytes)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		sw.payload = append(sw.payload, v...)
+	case int:
+		sw.payload = append(sw.payload, valueInt)
+		sw.payload = appendVarint(sw.payload, int64(v))
+	case int64:
+		sw.payload = append(sw.payload, valueInt)
+		sw.payload = appendVarint(sw.payload, v)
+	case float64:
+		sw.payload = append(sw.payload, valueFloat)
+		sw.payload = appendUvarint(sw.payload, math.Float64bits(v))
+	case bool:
+		sw.payload = append(sw.payload, valueBool)
+		if v {
+			sw.payload = append(sw.payload, 1)
+		} else {
+			sw.payload = append(sw.payload, 0)
+		}
+	case []interface{}:
+		sw.payload = append(sw.payload, valueList)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for _, item := range v {
+			if err := sw.appendValue(item); err != nil {
+				return err
+			}
+		}
+	case []string:
+		sw.payload = append(sw.payload, valueList)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for _, item := range v {
+			sw.payload = append(sw.payload, valueString)
+			sw.appendString(item)
+		}
+	case map[string]interface{}:
+		sw.payload = append(sw.payload, valueMap)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for key, item := range v {
+			sw.appendString(key)
+			if err := sw.appendValue(item); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		sw.payload = append(sw.payload, valueMap)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for key, item := range v {
+			sw.appendString(key)
+			sw.payload = append(sw.payload, valueString)
+			sw.appendString(item)
+		}
+	case Set:
+		sw.payload = append(sw.payload, valueSet)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for _, member := range v {
+			sw.appendString(member)
+		}
+	case ZSet:
+		sw.payload = append(sw.payload, valueZSet)
+		sw.payload = appendUvarint(sw.payload, uint64(len(v)))
+		for _, m := range v {
+			sw.appendString(m.Member)
+			sw.payload = appendUvarint(sw.payload, math.Float64bits(m.Score))
+		}
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+//readSnapshot decodes dump in binary format
+//With skipCorrupt records with checksum mismatch are skipped and counted instead of failing
+func readSnapshot(r io.Reader, skipCorrupt bool) (*dump, int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+3)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, 0, fmt.Errorf("cannot read snapshot header: %s", err.Error())
+	}
+	if !isBinarySnapshot(header) {
+		return nil, 0, errors.New("invalid snapshot magic")
+	}
+	version := int(header[len(snapshotMagic)]) | int(header[len(snapshotMagic)+1])<<8
+	if version != snapshotVersion {
+		return nil, 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	flags := header[len(snapshotMagic)+2]
+	if flags&flagGzip != 0 {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	d := &dump{
+		Data:     map[string]interface{}{},
+		Types:    map[string]string{},
+		PExpires: map[string]int64{},
+	}
+	skipped := 0
+	for n := 0; ; n++ {
+		kind, payload, err := readRecord(br)
+		if err == ErrChecksum && skipCorrupt && kind == recordEntry {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return d, skipped, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		sr := &snapshotReader{b: payload}
+		if kind == recordEnd {
+			count, err := sr.uvarint()
+			if err != nil {
+				return d, skipped, fmt.Errorf("record %d: %s", n, err.Error())
+			}
+			if count != uint64(len(d.Data)+skipped) {
+				return d, skipped, fmt.Errorf("snapshot contains %d entries, expected %d", len(d.Data)+skipped, count)
+			}
+			return d, skipped, nil
+		}
+		if kind != recordEntry {
+			return d, skipped, fmt.Errorf("record %d: unknown record kind %d", n, kind)
+		}
+		key, err := sr.string()
+		if err != nil {
+			return d, skipped, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		at, err := sr.varint()
+		if err != nil {
+			return d, skipped, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		value, err := sr.value()
+		if err != nil {
+			return d, skipped, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		switch v := value.(type) {
+		case counter:
+			value = string(v)
+			d.Types[key] = TypeCounter
+		case []byte:
+			d.Types[key] = TypeBytes
+		case Set:
+			d.Types[key] = TypeSet
+		case ZSet:
+			d.Types[key] = TypeZSet
+		}
+		d.Data[key] = value
+		if at > 0 {
+			d.PExpires[key] = at
+		}
+	}
+}
+
+//readRecord reads single record and verifies its checksum
+func readRecord(br *bufio.Reader) (byte, []byte, error) {
+	kind, err := br.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > math.MaxInt32 {
+		return 0, nil, fmt.Errorf("invalid record size %d", size)
+	}
+	capacity := size + 4
+	if capacity > recordChunk {
+		capacity = recordChunk
+	}
+	//buffer grows as data is read, so corrupted size fails with unexpected EOF instead of huge allocation
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	if _, err := io.CopyN(buf, br, int64(size)+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	payload := buf.Bytes()
+	sum := binary.LittleEndian.Uint32(payload[size:])
+	payload = payload[:size]
+	if crc32.ChecksumIEEE(payload) != sum {
+		return kind, payload, ErrChecksum
+	}
+	return kind, payload, nil
+}
+
+//snapshotReader - decoder of record payload
+type snapshotReader struct {
+	b []byte
+}
+
+var errShortPayload = errors.New("unexpected end of record")
+
+func (sr *snapshotReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(sr.b)
+	if n <= 0 {
+		return 0, errShortPayload
+	}
+	sr.b = sr.b[n:]
+	return v, nil
+}
+
+func (sr *snapshotReader) varint() (int64, error) {
+	v, n := binary.Varint(sr.b)
+	if n <= 0 {
+		return 0, errShortPayload
+	}
+	sr.b = sr.b[n:]
+	return v, nil
+}
+
+func (sr *snapshotReader) byte() (byte, error) {
+	if len(sr.b) == 0 {
+		return 0, errShortPayload
+	}
+	v := sr.b[0]
+	sr.b = sr.b[1:]
+	return v, nil
+}
+
+func (sr *snapshotReader) string() (string, error) {
+	size, err := sr.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if uint64(len(sr.b)) < size {
+		return "", errShortPayload
+	}
+	v := string(sr.b[:size])
+	sr.b = sr.b[size:]
+	return v, nil
+}
+
+//value decodes tagged value
+func (sr *snapshotReader) value() (interface{}, error) {
+	tag, err := sr.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case valueNil:
+		return nil, nil
+	case valueString:
+		return sr.string()
+	case valueCount:
+		v, err := sr.string()
+		return counter(v), err
+	case valueBytes:
+		v, err := sr.string()
+		return []byte(v), err
+	case valueInt:
+		return sr.varint()
+	case valueFloat:
+		bits, err := sr.uvarint()
+		return math.Float64frombits(bits), err
+	case valueBool:
+		b, err := sr.byte()
+		return b == 1, err
+	case valueList:
+		size, err := sr.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(sr.b)) {
+			return nil, errShortPayload
+		}
+		v := make([]interface{}, size)
+		for i := range v {
+			if v[i], err = sr.value(); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case valueMap:
+		size, err := sr.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(sr.b)) {
+			return nil, errShortPayload
+		}
+		v := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			key, err := sr.string()
+			if err != nil {
+				return nil, err
+			}
+			if v[key], err = sr.value(); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case valueSet:
+		size, err := sr.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(sr.b)) {
+			return nil, errShortPayload
+		}
+		v := make(Set, size)
+		for i := range v {
+			if v[i], err = sr.string(); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case valueZSet:
+		size, err := sr.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(sr.b)) {
+			return nil, errShortPayload
+		}
+		v := make(ZSet, size)
+		for i := range v {
+			if v[i].Member, err = sr.string(); err != nil {
+				return nil, err
+			}
+			bits, err := sr.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			v[i].Score = math.Float64frombits(bits)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unknown value tag %q", tag)
+	}
+}
@@ -1,119 +1,453 @@
 package store
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"sync"
 	"time"
-
-	"github.com/gobwas/glob"
 )
 
-//Store implements in-memory key-value cache
+//DefaultShards - number of shards used when Options.Shards is not set
+const DefaultShards = 32
+
+//Options - store settings
+//DumpGenerations is number of previous dumps kept as DumpFile.1 ... DumpFile.N
+//DumpFormat is FormatBinary (default) or FormatJSON, DumpCompression - CompressionNone (default) or CompressionGzip
+//AOFFile enables append-only log, AOFFsync is one of FsyncAlways, FsyncEverySec (default) or FsyncNo
+//Recovery is one of RecoveryStrict (default), RecoveryQuarantine or RecoveryPartial
+//MaxMemory (approximate bytes) and MaxKeys limit store size, EvictionPolicy chooses keys removed when limit is reached
+type Options struct {
+	Shards             int
+	DumpFile           string
+	DumpInterval       int64
+	DumpGenerations    int
+	DumpFormat         string
+	DumpCompression    string
+	AOFFile            string
+	AOFFsync           string
+	AOFRewriteInterval int64
+	Recovery           string
+	MaxMemory          int64
+	MaxKeys            int64
+	EvictionPolicy     string
+}
+
+//Store implements in-memory key-value cache split into independently locked shards
 type Store struct {
-	sync.RWMutex `json:"-"`
-	Data         map[string]interface{} `json:"data"`
-	Expires      map[string]int64       `json:"expires"`
-	DumpFile     string                 `json:"-"`
-	DumpInterval int64                  `json:"-"`
+	shards             []*shard
+	DumpFile           string
+	DumpInterval       int64
+	DumpGenerations    int
+	DumpFormat         string
+	DumpCompression    string
+	AOFFile            string
+	AOFFsync           string
+	AOFRewriteInterval int64
+	Recovery           string
+	MaxMemory          int64
+	MaxKeys            int64
+	EvictionPolicy     string
+	mem                *memory
+	report             LoadReport
+	done               chan struct{}
+	waitDone           chan struct{}
+	closeWaiters       sync.Once
+	closing            sync.Once
+	workers            sync.WaitGroup
+	now                func() time.Time
+	stats              dumpStats
+	saving             sync.Mutex
+	aof                *aof
+	patterns           patternCache
+	events             *notifier
 }
 
-//New creates Store, runs workers for removing expired keys and autosave storage into file
-func New(dumpFile string, dumpInterval int64) *Store {
-	s := &Store{
-		Data:         map[string]interface{}{},
-		Expires:      map[string]int64{},
-		DumpFile:     dumpFile,
-		DumpInterval: dumpInterval,
-	}
-	if dumpInterval > 0 {
-		fileData, err := ioutil.ReadFile(dumpFile)
-		if err != nil {
-			log.Printf("Error loading storage dump: %s\n", err.Error())
-		} else {
-			json.Unmarshal(fileData, s)
+//shard - part of keyspace protected by its own lock
+//version is the last version assigned to value of shard
+type shard struct {
+	sync.RWMutex
+	data    map[string]*item
+	expires map[string]*ttl
+	ttls    ttlHeap
+	mem     *memory
+	events  *notifier
+	waiters map[string][]chan struct{}
+	version uint64
+}
+
+//item - stored value with its type, version, approximate size and access statistics used by eviction
+type item struct {
+	value   interface{}
+	typ     string
+	version uint64
+	size    int64
+	access  int64
+	freq    uint32
+	hits    uint32
+}
+
+//New creates Store, loads data from AOF or dump, runs workers for removing expired keys and autosave storage into file
+//Returns error if data cannot be loaded and Recovery mode does not allow to start
+func New(opts Options) (*Store, error) {
+	s := newStore(opts)
+	if s.AOFFile != "" || s.DumpInterval > 0 {
+		s.startVersions()
+	}
+	loaded := false
+	var err error
+	if s.AOFFile != "" {
+		if loaded, err = s.replayAOF(); err != nil {
+			return nil, err
+		}
+	}
+	if s.DumpInterval > 0 && !loaded {
+		if err = s.load(); err != nil {
+			return nil, err
+		}
+	}
+	logReport(&s.report)
+	if s.AOFFile != "" {
+		if err = s.openAOF(loaded); err != nil {
+			return nil, err
 		}
+		s.workers.Add(1)
+		go s.aofWorker()
+	}
+	if s.DumpInterval > 0 {
+		s.workers.Add(1)
 		go s.dumpWorker()
 	}
+	s.workers.Add(1)
 	go s.expiresWorker()
+	return s, nil
+}
+
+//Close stops background workers, writes final dump and closes AOF
+//Store is closed once, next calls do nothing and return nil
+func (s *Store) Close() error {
+	var err error
+	s.closing.Do(func() {
+		err = s.close()
+	})
+	return err
+}
+
+//close implements Close
+func (s *Store) close() error {
+	s.CloseSubscriptions()
+	s.CloseWaiters()
+	close(s.done)
+	s.workers.Wait()
+	var err error
+	if s.DumpInterval > 0 {
+		if _, saveErr := s.Save(); saveErr != nil {
+			err = fmt.Errorf("cannot save final dump: %s", saveErr.Error())
+		}
+	}
+	if s.aof != nil {
+		if closeErr := s.closeAOF(); closeErr != nil && err == nil {
+			err = fmt.Errorf("cannot close AOF: %s", closeErr.Error())
+		}
+	}
+	return err
+}
+
+//newStore creates empty Store without background workers
+func newStore(opts Options) *Store {
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultShards
+	}
+	if opts.DumpFormat == "" {
+		opts.DumpFormat = FormatBinary
+	}
+	if opts.AOFFsync == "" {
+		opts.AOFFsync = FsyncEverySec
+	}
+	if opts.Recovery == "" {
+		opts.Recovery = RecoveryStrict
+	}
+	if opts.EvictionPolicy == "" {
+		opts.EvictionPolicy = PolicyNoEviction
+	}
+	s := &Store{
+		shards:             make([]*shard, opts.Shards),
+		DumpFile:           opts.DumpFile,
+		DumpInterval:       opts.DumpInterval,
+		DumpGenerations:    opts.DumpGenerations,
+		DumpFormat:         opts.DumpFormat,
+		DumpCompression:    opts.DumpCompression,
+		AOFFile:            opts.AOFFile,
+		AOFFsync:           opts.AOFFsync,
+		AOFRewriteInterval: opts.AOFRewriteInterval,
+		Recovery:           opts.Recovery,
+		MaxMemory:          opts.MaxMemory,
+		MaxKeys:            opts.MaxKeys,
+		EvictionPolicy:     opts.EvictionPolicy,
+		mem:                &memory{},
+		events:             &notifier{},
+		now:                time.Now,
+		done:               make(chan struct{}),
+		waitDone:           make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			data:    map[string]*item{},
+			expires: map[string]*ttl{},
+			mem:     s.mem,
+			events:  s.events,
+		}
+	}
 	return s
 }
 
-//expiresWorker removes expired keys
-func (s *Store) expiresWorker() {
-	for {
-		<-time.After(1 * time.Second)
-		go func() {
-			currentTime := time.Now().Unix()
-			for key, value := range s.Expires {
-				if currentTime >= value {
-					s.Remove(key)
-				}
+//shard returns shard which owns key
+func (s *Store) shard(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+//shardIndex returns index of shard which owns key (FNV-1a hash)
+func (s *Store) shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s.shards)))
+}
+
+//rlockKeys locks shards of keys for reading in order of shard indexes (so concurrent calls cannot deadlock)
+//Returns function which unlocks them
+func (s *Store) rlockKeys(keys []string) func() {
+	locked := make([]bool, len(s.shards))
+	for _, key := range keys {
+		locked[s.shardIndex(key)] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			s.shards[i].RLock()
+		}
+	}
+	return func() {
+		for i, ok := range locked {
+			if ok {
+				s.shards[i].RUnlock()
+			}
+		}
+	}
+}
+
+//lockKeys locks shards of keys for writing in order of shard indexes, returns function which unlocks them
+func (s *Store) lockKeys(keys []string) func() {
+	locked := make([]bool, len(s.shards))
+	for _, key := range keys {
+		locked[s.shardIndex(key)] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			s.shards[i].Lock()
+		}
+	}
+	return func() {
+		for i, ok := range locked {
+			if ok {
+				s.shards[i].Unlock()
 			}
-		}()
+		}
+	}
+}
+
+//lookup returns item of not expired key, shard must be locked at least for reading
+func (sh *shard) lookup(key string, currentTime int64) (*item, bool) {
+	it, ok := sh.data[key]
+	if !ok || sh.expired(key, currentTime) {
+		return nil, false
+	}
+	return it, true
+}
+
+//set stores value of type keeping expiration time of existing key, shard must be locked for writing
+func (sh *shard) set(key string, value interface{}, typ string, currentTime int64) *item {
+	if sh.expired(key, currentTime) {
+		sh.removeExpires(key)
+	}
+	it := &item{
+		value:   value,
+		typ:     typ,
+		version: sh.nextVersion(),
+		size:    itemSize(key, value),
+		access:  currentTime,
+		freq:    lfuInitial,
 	}
+	if old, ok := sh.data[key]; ok {
+		it.freq = old.freq
+		sh.mem.add(it.size-old.size, 0)
+	} else {
+		sh.mem.add(it.size, 1)
+	}
+	sh.data[key] = it
+	sh.notify(EventSet, key, it.version)
+	return it
 }
 
-//dumpWorker saves store to file
-func (s *Store) dumpWorker() {
-	for {
-		<-time.After(time.Duration(s.DumpInterval) * time.Second)
-		j, _ := json.Marshal(s)
-		ioutil.WriteFile(s.DumpFile, j, 0644)
+//drop deletes value of key, shard must be locked for writing
+func (sh *shard) drop(key string) {
+	if it, ok := sh.data[key]; ok {
+		sh.mem.add(-it.size, -1)
+		delete(sh.data, key)
 	}
 }
 
-//Set value associated with key
-func (s *Store) Set(key string, value interface{}) {
-	s.Lock()
-	s.Data[key] = value
-	s.Unlock()
+//remove deletes key with its expiration time, shard must be locked for writing
+func (sh *shard) remove(key string) {
+	sh.removeAs(key, EventRemove)
+}
+
+//removeAs deletes key with its expiration time and notifies subscribers with event of kind if key exists
+func (sh *shard) removeAs(key string, kind string) {
+	if _, ok := sh.data[key]; ok {
+		sh.drop(key)
+		sh.notify(kind, key, 0)
+	}
+	sh.removeExpires(key)
+}
+
+//Set value associated with key, returns ErrOutOfMemory if limits are reached and nothing can be evicted
+func (s *Store) Set(key string, value interface{}) error {
+	_, err := s.SetIf(key, value, 0, Condition{})
+	return err
+}
+
+//SetWithExpires sets value and its expiration time in milliseconds at once
+func (s *Store) SetWithExpires(key string, value interface{}, expires int64) error {
+	stored, typ, err := storedValue("", value)
+	if err != nil {
+		return err
+	}
+	if err := s.reserve(key, value); err != nil {
+		return err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	currentTime := unixMilli(s.now())
+	sh.set(key, stored, typ, currentTime)
+	sh.setExpires(key, currentTime+expires)
+	s.appendAOF(&aofRecord{Op: "set", Key: key, Value: value, Type: recordType(typ), At: currentTime + expires, Time: currentTime})
+	sh.Unlock()
+	return nil
 }
 
 //Get value by key
+//Sets and sorted sets are modified in place, so they are returned as Set and ZSet copies
 func (s *Store) Get(key string) (interface{}, bool) {
-	s.RLock()
-	defer s.RUnlock()
-	if value, ok := s.Data[key]; ok {
-		return value, true
+	e, ok := s.GetEntry(key)
+	return e.Value, ok
+}
+
+//GetWithType returns value by key together with its type
+func (s *Store) GetWithType(key string) (interface{}, string, bool) {
+	e, ok := s.GetEntry(key)
+	return e.Value, e.Type, ok
+}
+
+//Type returns type of value stored by key, returns false if key does not exist
+func (s *Store) Type(key string) (string, bool) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	it, ok := sh.lookup(key, unixMilli(s.now()))
+	if !ok {
+		return "", false
 	}
-	return nil, false
+	return it.typ, true
 }
 
 //Remove key
 func (s *Store) Remove(key string) {
-	s.Lock()
-	delete(s.Data, key)
-	delete(s.Expires, key)
-	s.Unlock()
+	sh := s.shard(key)
+	sh.Lock()
+	sh.remove(key)
+	s.appendAOF(&aofRecord{Op: "remove", Key: key})
+	sh.Unlock()
 }
 
-//Keys returns all keys by glob pattern
-func (s *Store) Keys(pattern string) []string {
-	e := glob.MustCompile(pattern)
+//Keys returns all keys by glob pattern, returns *PatternError if pattern is malformed
+func (s *Store) Keys(pattern string) ([]string, error) {
+	return s.keys(pattern, false)
+}
+
+//KeysRegexp returns all keys matching regular expression, returns *PatternError if expression is malformed
+func (s *Store) KeysRegexp(pattern string) ([]string, error) {
+	return s.keys(pattern, true)
+}
+
+func (s *Store) keys(pattern string, regex bool) ([]string, error) {
+	m, err := s.patterns.compile(pattern, regex)
+	if err != nil {
+		return nil, err
+	}
 	keys := []string{}
-	for key := range s.Data {
-		if e.Match(key) {
-			keys = append(keys, key)
+	currentTime := unixMilli(s.now())
+	for _, sh := range s.shards {
+		sh.RLock()
+		for key := range sh.data {
+			if m.Match(key) && !sh.expired(key, currentTime) {
+				keys = append(keys, key)
+			}
 		}
+		sh.RUnlock()
 	}
-	return keys
+	return keys, nil
+}
+
+//SetExpires setup expires time for key in seconds, returns false if key does not exist
+func (s *Store) SetExpires(key string, expires int64) bool {
+	return s.SetPExpires(key, expires*1000)
 }
 
-//SetExpires setup expires time for key in seconds
-func (s *Store) SetExpires(key string, expires int64) {
-	s.Lock()
-	s.Expires[key] = time.Now().Unix() + expires
-	s.Unlock()
+//SetPExpires setup expires time for key in milliseconds, returns false if key does not exist
+func (s *Store) SetPExpires(key string, expires int64) bool {
+	return s.SetExpiresAt(key, s.now().Add(time.Duration(expires)*time.Millisecond))
+}
+
+//SetExpiresAt setup absolute expiration time for key, returns false if key does not exist
+func (s *Store) SetExpiresAt(key string, at time.Time) bool {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	if _, ok := sh.lookup(key, unixMilli(s.now())); !ok {
+		return false
+	}
+	sh.setExpires(key, unixMilli(at))
+	s.appendAOF(&aofRecord{Op: "expire", Key: key, At: unixMilli(at)})
+	return true
 }
 
-//GetExpires returns seconds until key expires
+//Persist removes expiration time of key, returns false if key does not exist or has no expiration time
+func (s *Store) Persist(key string) bool {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	if _, ok := sh.expires[key]; !ok || sh.expired(key, unixMilli(s.now())) {
+		return false
+	}
+	sh.removeExpires(key)
+	s.appendAOF(&aofRecord{Op: "persist", Key: key})
+	return true
+}
+
+//GetExpires returns seconds until key expires (rounded to nearest second)
 func (s *Store) GetExpires(key string) (int64, bool) {
-	s.RLock()
-	defer s.RUnlock()
-	if value, ok := s.Expires[key]; ok {
-		return value - time.Now().Unix(), true
+	expires, ok := s.GetPExpires(key)
+	return (expires + 500) / 1000, ok
+}
+
+//GetPExpires returns milliseconds until key expires
+func (s *Store) GetPExpires(key string) (int64, bool) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	currentTime := unixMilli(s.now())
+	if t, ok := sh.expires[key]; ok && currentTime < t.at {
+		return t.at - currentTime, true
 	}
 	return 0, false
 }
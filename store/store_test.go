@@ -1,24 +1,38 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSetGet(t *testing.T) {
-	s := &Store{
-		Data: map[string]interface{}{},
-	}
+	s := newStore(Options{})
 	tests := []struct {
 		Key           string
 		Value         interface{}
 		BeingSet      bool
 		ExpectedError bool
+		Expected      interface{}
 	}{
-		{"name", "John Doe", true, false},
-		{"hobbies", []string{"web", "sport"}, true, false},
-		{"hello world", map[string]string{"programming": "Golang"}, true, false},
-		{"unset", "this item will not being set", false, true},
+		{"name", "John Doe", true, false, "John Doe"},
+		{"hobbies", []string{"web", "sport"}, true, false, []interface{}{"web", "sport"}},
+		{"hello world", map[string]string{"programming": "Golang"}, true, false, map[string]interface{}{"programming": "Golang"}},
+		{"unset", "this item will not being set", false, true, nil},
 	}
 
 	for _, test := range tests {
@@ -29,8 +43,1669 @@ func TestSetGet(t *testing.T) {
 		if ok && test.ExpectedError || !ok && !test.ExpectedError {
 			t.Errorf("Expected error: %t, received: %v", test.ExpectedError, ok)
 		}
-		if v != nil && !reflect.DeepEqual(v, test.Value) {
-			t.Errorf("Unequal values. Expected: %v, received: %v", test.Value, v)
+		if v != nil && !reflect.DeepEqual(v, test.Expected) {
+			t.Errorf("Unequal values. Expected: %v, received: %v", test.Expected, v)
+		}
+	}
+
+	//slice of strings is stored as list which does not share memory with it
+	hobbies := []string{"web", "sport"}
+	s.Set("hobbies", hobbies)
+	hobbies[0] = "changed"
+	if n, err := s.RPush("hobbies", "music"); err != nil || n != 3 {
+		t.Errorf("Wrong result of rpush to list set from strings: got %d (%v), expected %d", n, err, 3)
+	}
+	if v, _ := s.LRange("hobbies", 0, -1); !reflect.DeepEqual(v, []interface{}{"web", "sport", "music"}) {
+		t.Errorf("Wrong list set from strings: got %v", v)
+	}
+
+	//map of strings is stored as hash
+	s.Set("user", map[string]string{"name": "John"})
+	if n, err := s.HSet("user", map[string]string{"city": "Moscow"}); err != nil || n != 1 {
+		t.Errorf("Wrong result of hset to hash set from strings: got %d (%v), expected %d", n, err, 1)
+	}
+	if v, ok, err := s.HGet("user", "name"); err != nil || !ok || v != "John" {
+		t.Errorf("Wrong result of hget from hash set from strings: got %v (%v)", v, err)
+	}
+	if n, err := s.HDel("user", "name"); err != nil || n != 1 {
+		t.Errorf("Wrong result of hdel from hash set from strings: got %d (%v), expected %d", n, err, 1)
+	}
+}
+
+func TestKeysAcrossShards(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("key%d", i), "value")
+	}
+	s.Set("other", "value")
+	if keys, _ := s.Keys("key*"); len(keys) != 100 {
+		t.Errorf("Wrong number of keys: got %d, expected %d", len(keys), 100)
+	}
+}
+
+func TestKeysPattern(t *testing.T) {
+	s := newStore(Options{})
+	for _, key := range []string{"users/1", "users/2", "users/admin", "groups/1"} {
+		s.Set(key, "value")
+	}
+	tests := []struct {
+		Pattern       string
+		Regex         bool
+		ExpectedKeys  []string
+		ExpectedError bool
+	}{
+		{"users/*", false, []string{"users/1", "users/2", "users/admin"}, false},
+		{"*/1", false, []string{"groups/1", "users/1"}, false},
+		{"users/[", false, nil, true},
+		{`^users/\d+$`, true, []string{"users/1", "users/2"}, false},
+		{"admin", true, []string{"users/admin"}, false},
+		{"users/(", true, nil, true},
+	}
+	for _, test := range tests {
+		var keys []string
+		var err error
+		if test.Regex {
+			keys, err = s.KeysRegexp(test.Pattern)
+		} else {
+			keys, err = s.Keys(test.Pattern)
+		}
+		if test.ExpectedError {
+			if _, ok := err.(*PatternError); !ok {
+				t.Errorf("Wrong error for %s: got %v, expected *PatternError", test.Pattern, err)
+			}
+			continue
+		}
+		sort.Strings(keys)
+		if err != nil || !reflect.DeepEqual(keys, test.ExpectedKeys) {
+			t.Errorf("Wrong keys for %s: got %v %v, expected %v", test.Pattern, keys, err, test.ExpectedKeys)
+		}
+	}
+	if n := len(s.patterns.compiled); n != 4 {
+		t.Errorf("Wrong number of cached patterns: got %d, expected %d", n, 4)
+	}
+	s.Keys("users/*")
+	if n := len(s.patterns.compiled); n != 4 {
+		t.Errorf("Compiled pattern must being reused: got %d cached patterns, expected %d", n, 4)
+	}
+}
+
+func TestScan(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	for i := 0; i < 500; i++ {
+		s.Set(fmt.Sprintf("stable%d", i), "value")
+	}
+	s.RPush("list", "a")
+	s.SAdd("stable-set", "a")
+
+	//keys are added and removed concurrently with scan
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("temp%d", i%100)
+			if i%2 == 0 {
+				s.Set(key, "value")
+			} else {
+				s.Remove(key)
+			}
+		}
+	}()
+	seen := map[string]int{}
+	cursor := ""
+	for calls := 0; ; calls++ {
+		if calls > 1000 {
+			t.Fatal("Scan is not finished")
+		}
+		keys, next, err := s.Scan(cursor, "stable*", "", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 7 {
+			t.Errorf("Wrong number of keys: got %d, expected at most %d", len(keys), 7)
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == "0" {
+			break
+		}
+		cursor = next
+	}
+	close(stop)
+	<-done
+	if len(seen) != 501 {
+		t.Errorf("Wrong number of scanned keys: got %d, expected %d", len(seen), 501)
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Key %s is returned %d times", key, n)
+		}
+	}
+
+	if keys, next, _ := s.Scan("0", "", TypeSet, 100); !reflect.DeepEqual(keys, []string{"stable-set"}) || next != "0" {
+		t.Errorf("Wrong keys of type set: got %v %s, expected [stable-set] 0", keys, next)
+	}
+	single := newStore(Options{Shards: 1})
+	for _, key := range []string{"e", "b", "f", "a", "d", "c"} {
+		single.Set(key, "value")
+	}
+	keys, next, _ := single.Scan("", "", "", 4)
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Wrong smallest keys: got %v", keys)
+	}
+	if keys, _, _ := single.Scan(next, "", "", 4); !reflect.DeepEqual(keys, []string{"e", "f"}) {
+		t.Errorf("Wrong keys after cursor: got %v", keys)
+	}
+	if _, _, err := s.Scan("bad cursor", "", "", 10); err != ErrInvalidCursor {
+		t.Errorf("Wrong error of invalid cursor: got %v, expected %v", err, ErrInvalidCursor)
+	}
+	if _, _, err := s.Scan("", "[", "", 10); err == nil {
+		t.Errorf("Invalid pattern must return error")
+	} else if _, ok := err.(*PatternError); !ok {
+		t.Errorf("Wrong error of invalid pattern: got %T, expected *PatternError", err)
+	}
+	if _, _, err := s.Scan("", "", "queue", 10); err != ErrUnknownType {
+		t.Errorf("Wrong error of unknown type: got %v, expected %v", err, ErrUnknownType)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	currentTime := time.Unix(1000, 0)
+	s.now = func() time.Time { return currentTime }
+	for i := 0; i < 10; i++ {
+		s.Set(fmt.Sprintf("key%d", i), "value")
+		s.SetExpires(fmt.Sprintf("key%d", i), int64(i+1))
+	}
+	s.Set("persistent", "value")
+	if s.SetExpires("missing", 1) {
+		t.Errorf("Expiration time must not being set for missing key")
+	}
+	if _, ok := s.GetExpires("missing"); ok {
+		t.Errorf("Expiration time must not being set for missing key")
+	}
+
+	currentTime = time.Unix(1005, 0)
+	if _, ok := s.Get("key3"); ok {
+		t.Errorf("Expired key key3 must not being returned")
+	}
+	if _, ok := s.Get("key5"); !ok {
+		t.Errorf("Key key5 must not being expired yet")
+	}
+	if expires, _ := s.GetExpires("key5"); expires != 1 {
+		t.Errorf("Wrong expiration time: got %d, expected %d", expires, 1)
+	}
+	if keys, _ := s.Keys("*"); len(keys) != 6 {
+		t.Errorf("Wrong number of keys: got %d, expected %d", len(keys), 6)
+	}
+
+	s.SetExpires("key9", 100)
+	s.Remove("key8")
+	for _, sh := range s.shards {
+		sh.removeExpired(unixMilli(currentTime))
+	}
+	total, ttls := 0, 0
+	for _, sh := range s.shards {
+		total += len(sh.data)
+		ttls += len(sh.ttls)
+	}
+	if total != 5 || ttls != 4 {
+		t.Errorf("Wrong number of keys after expiration: got %d keys and %d ttls, expected 5 and 4", total, ttls)
+	}
+}
+
+func TestPExpires(t *testing.T) {
+	s := newStore(Options{})
+	currentTime := time.Unix(1000, 0)
+	s.now = func() time.Time { return currentTime }
+	s.Set("lock", "owner")
+	s.Set("window", "1")
+	s.SetPExpires("lock", 250)
+	s.SetExpiresAt("window", time.Unix(1001, 500*int64(time.Millisecond)))
+
+	if expires, _ := s.GetPExpires("lock"); expires != 250 {
+		t.Errorf("Wrong expiration time: got %d, expected %d", expires, 250)
+	}
+	if expires, _ := s.GetExpires("window"); expires != 2 {
+		t.Errorf("Wrong expiration time: got %d, expected %d", expires, 2)
+	}
+
+	currentTime = currentTime.Add(250 * time.Millisecond)
+	if _, ok := s.Get("lock"); ok {
+		t.Errorf("Expired key lock must not being returned")
+	}
+	if expires, _ := s.GetPExpires("window"); expires != 1250 {
+		t.Errorf("Wrong expiration time: got %d, expected %d", expires, 1250)
+	}
+}
+
+func TestSetWithExpiresPersist(t *testing.T) {
+	s := newStore(Options{})
+	currentTime := time.Unix(1000, 0)
+	s.now = func() time.Time { return currentTime }
+	s.SetWithExpires("session", "data", 1000)
+	if expires, ok := s.GetPExpires("session"); !ok || expires != 1000 {
+		t.Errorf("Wrong expiration time: got %d, expected %d", expires, 1000)
+	}
+	if !s.Persist("session") {
+		t.Errorf("Expiration time must being removed")
+	}
+	if s.Persist("session") || s.Persist("missing") {
+		t.Errorf("Persist must fail for key without expiration time")
+	}
+	currentTime = currentTime.Add(time.Hour)
+	if _, ok := s.Get("session"); !ok {
+		t.Errorf("Persisted key must not expire")
+	}
+}
+
+func TestSaveGenerations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dumpFile := filepath.Join(dir, "dump.json")
+
+	s := newStore(Options{DumpFile: dumpFile, DumpGenerations: 2})
+	for i := 0; i < 4; i++ {
+		s.SetWithExpires("counter", strconv.Itoa(i), 60000)
+		if _, err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 {
+		t.Errorf("Wrong number of dump files: got %v, expected dump file and 2 generations", files)
+	}
+
+	tests := []struct {
+		File     string
+		Expected string
+	}{
+		{dumpFile, "3"},
+		{dumpFile + ".1", "2"},
+		{dumpFile + ".2", "1"},
+	}
+	for _, test := range tests {
+		l := newStore(Options{DumpFile: test.File})
+		l.load()
+		if v, _ := l.Get("counter"); v != test.Expected {
+			t.Errorf("Wrong value in %s: got %v, expected %s", test.File, v, test.Expected)
+		}
+		if _, ok := l.GetExpires("counter"); !ok {
+			t.Errorf("Expiration time must being loaded from %s", test.File)
+		}
+	}
+
+	if stats := s.Stats(); stats["dumpSaves"] != int64(4) || stats["dumpFailures"] != int64(0) {
+		t.Errorf("Wrong dump stats: %v", stats)
+	}
+
+	//Concurrent saves do not interfere with rotation of each other
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Save(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 3 {
+		t.Errorf("Wrong number of dump files after concurrent saves: got %v", files)
+	}
+}
+
+func TestAOF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{AOFFile: filepath.Join(dir, "kvstore.aof"), AOFFsync: FsyncAlways}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("name", "John Doe")
+	s.Set("hobbies", []interface{}{"web", "sport"})
+	s.SetWithExpires("session", "data", 60000)
+	s.Set("temp", "value")
+	s.SetExpires("temp", 60)
+	s.Persist("temp")
+	s.Remove("hobbies")
+	s.Set("name", "Jane Doe")
+	s.SAdd("tags", "go", "cache", "db")
+	s.SRem("tags", "db")
+	s.ZAdd("leaders", ZMember{"john", 10}, ZMember{"jane", 20})
+	s.ZIncrBy("leaders", "john", 15)
+	s.ZRem("leaders", "jane")
+	s.Incr("visits")
+	s.SetWithExpires("blob", []byte{0, 1, 0xff}, 60000)
+	s.SetWithExpires("old", "value", 60000)
+	s.Rename("old", "renamed")
+	s.Copy("tags", "tags-copy", false)
+	s.RPush("queue", "a", "b", "c")
+	s.LPush("queue", "z")
+	s.LPop("queue")
+	s.RPop("queue")
+	s.LSet("queue", 0, "x")
+	s.LInsert("queue", false, "x", "y")
+	s.LRem("queue", 0, "b")
+	s.RPush("queue", "d", "e")
+	s.LTrim("queue", 1, -1)
+	s.RPush("popped", "a")
+	s.LPop("popped")
+	s.HSet("profile", map[string]string{"name": "John", "city": "Moscow"})
+	s.HIncrBy("profile", "visits", 3)
+	s.HDel("profile", "city")
+	before, _ := s.Version("name")
+
+	//Simulate crash in the middle of writing record
+	f, _ := os.OpenFile(opts.AOFFile, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"op":"set","key":"partial"`)
+	f.Close()
+
+	checkLoaded := func(l *Store) {
+		if v, _ := l.Get("name"); v != "Jane Doe" {
+			t.Errorf("Wrong value: got %v, expected %v", v, "Jane Doe")
+		}
+		if _, ok := l.Get("hobbies"); ok {
+			t.Errorf("Removed key must not being loaded")
+		}
+		if _, ok := l.GetExpires("session"); !ok {
+			t.Errorf("Expiration time must being loaded")
+		}
+		if _, ok := l.GetExpires("temp"); ok {
+			t.Errorf("Persisted key must not have expiration time")
+		}
+		if _, ok := l.Get("partial"); ok {
+			t.Errorf("Truncated record must not being loaded")
+		}
+		if v, _ := l.Get("tags"); !reflect.DeepEqual(v, Set{"cache", "go"}) {
+			t.Errorf("Wrong set: got %v", v)
+		}
+		if v, _ := l.Get("leaders"); !reflect.DeepEqual(v, ZSet{{"john", 25}}) {
+			t.Errorf("Wrong sorted set: got %v", v)
+		}
+		if typ, _ := l.Type("visits"); typ != TypeCounter {
+			t.Errorf("Wrong type of counter: got %s", typ)
+		}
+		if v, _ := l.Get("blob"); !bytes.Equal(v.([]byte), []byte{0, 1, 0xff}) {
+			t.Errorf("Wrong bytes: got %v", v)
+		}
+		if _, ok := l.GetExpires("renamed"); !ok {
+			t.Errorf("Expiration time of renamed key must being loaded")
+		}
+		if _, ok := l.Get("old"); ok {
+			t.Errorf("Renamed key must not being loaded")
+		}
+		if typ, _ := l.Type("tags-copy"); typ != TypeSet {
+			t.Errorf("Wrong type of copied set: got %s", typ)
+		}
+		if v, _ := l.Get("queue"); !reflect.DeepEqual(v, []interface{}{"y", "d", "e"}) {
+			t.Errorf("Wrong list: got %v", v)
+		}
+		if _, ok := l.Get("popped"); ok {
+			t.Errorf("Empty list must not being loaded")
+		}
+		if v, _ := l.Get("profile"); !reflect.DeepEqual(v, map[string]interface{}{"name": "John", "visits": "3"}) {
+			t.Errorf("Wrong hash: got %v", v)
+		}
+	}
+
+	//Versions of restarted store keep growing
+	time.Sleep(2 * time.Millisecond)
+	l, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(l)
+	if after, _ := l.Version("name"); after <= before {
+		t.Errorf("Version must grow after restart: got %d, previous %d", after, before)
+	}
+	l.Set("after", "truncate")
+	if err := l.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	l.Set("after", "rewrite")
+
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(r)
+	if v, _ := r.Get("after"); v != "rewrite" {
+		t.Errorf("Wrong value: got %v, expected %v", v, "rewrite")
+	}
+}
+
+func TestAOFExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{AOFFile: filepath.Join(dir, "kvstore.aof"), AOFFsync: FsyncAlways}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetWithExpires("k", "v1", 50)
+	s.Set("k", "v2")
+	s.RPush("q", "a")
+	s.SetPExpires("q", 50)
+	s.RPush("q", "b")
+	s.RPush("late", "a")
+	s.SetPExpires("late", 50)
+	time.Sleep(100 * time.Millisecond)
+	//list is created again after previous one expired
+	s.RPush("late", "b")
+	s.Close()
+
+	l, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, ok := l.Get("k"); ok {
+		t.Errorf("Value set before deadline must expire")
+	}
+	if v, ok := l.Get("q"); ok {
+		t.Errorf("Expired list must not being loaded: got %v", v)
+	}
+	if v, _ := l.Get("late"); !reflect.DeepEqual(v, []interface{}{"b"}) {
+		t.Errorf("Wrong list created after expiration: got %v", v)
+	}
+	if _, ok := l.GetExpires("late"); ok {
+		t.Errorf("List created after expiration must not have expiration time")
+	}
+}
+
+func TestAOFMembers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{AOFFile: filepath.Join(dir, "kvstore.aof"), AOFFsync: FsyncNo}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const members = 5000
+	for i := 0; i < members; i++ {
+		s.SAdd("set", strconv.Itoa(i))
+		s.ZAdd("zset", ZMember{strconv.Itoa(i), float64(i)})
+	}
+	s.SRem("set", "0", "1")
+	s.ZRem("zset", "0")
+	s.Close()
+
+	start := time.Now()
+	l, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Replay of members must not rebuild values: took %s", elapsed)
+	}
+	if n, _ := l.SCard("set"); n != members-2 {
+		t.Errorf("Wrong number of set members: got %d, expected %d", n, members-2)
+	}
+	if n, _ := l.ZCard("zset"); n != members-1 {
+		t.Errorf("Wrong number of sorted set members: got %d, expected %d", n, members-1)
+	}
+	for _, key := range []string{"set", "zset"} {
+		if it := l.shard(key).data[key]; it.size != itemSize(key, it.value) {
+			t.Errorf("Wrong size of %s: got %d, expected %d", key, it.size, itemSize(key, it.value))
+		}
+	}
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		Format      string
+		Compression string
+	}{
+		{FormatBinary, CompressionNone},
+		{FormatBinary, CompressionGzip},
+		{FormatJSON, CompressionNone},
+	}
+	for _, test := range tests {
+		dumpFile := filepath.Join(dir, test.Format+test.Compression)
+		s := newStore(Options{DumpFile: dumpFile, DumpFormat: test.Format, DumpCompression: test.Compression})
+		s.Set("name", "John Doe")
+		s.Set("hobbies", []interface{}{"web", "sport"})
+		s.Set("langs", map[string]interface{}{"programming": "Golang"})
+		s.SetWithExpires("session", "data", 60000)
+		s.SAdd("tags", "go", "cache")
+		s.ZAdd("leaders", ZMember{"john", 10}, ZMember{"jane", 20.5})
+		s.IncrBy("visits", 3, 0)
+		s.Set("blob", []byte{0, 1, 0xff})
+		if _, err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		l := newStore(Options{DumpFile: dumpFile})
+		l.load()
+		for _, key := range []string{"name", "hobbies", "langs", "session", "tags", "leaders", "visits", "blob"} {
+			expected, typ, _ := s.GetWithType(key)
+			if v, loadedTyp, _ := l.GetWithType(key); !reflect.DeepEqual(v, expected) || loadedTyp != typ {
+				t.Errorf("Wrong value of %s in %s dump: got %v (%s), expected %v (%s)", key, test.Format, v, loadedTyp, expected, typ)
+			}
+		}
+		if _, ok := l.GetExpires("session"); !ok {
+			t.Errorf("Expiration time must being loaded from %s dump", test.Format)
+		}
+	}
+}
+
+func TestSnapshotChecksum(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+	buf := &bytes.Buffer{}
+	if err := writeSnapshot(buf, s.snapshot(), CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	i := bytes.Index(b, []byte("John Doe"))
+	b[i] = 'j'
+	if _, _, err := readSnapshot(bytes.NewReader(b), false); err == nil || !strings.Contains(err.Error(), ErrChecksum.Error()) {
+		t.Errorf("Expected checksum error, received: %v", err)
+	}
+
+	//Corrupted size of record must not allocate memory for it
+	corrupt := append([]byte(snapshotMagic), snapshotVersion, 0, 0, recordEntry)
+	corrupt = append(corrupt, 0xfe, 0xff, 0xff, 0xff, 0x07)
+	corrupt = append(corrupt, "short payload"...)
+	if _, _, err := readSnapshot(bytes.NewReader(corrupt), false); err == nil || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Errorf("Expected unexpected EOF, received: %v", err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Binary dump with corrupted value of key "broken"
+	binaryDump := &bytes.Buffer{}
+	d := &dump{
+		Data:     map[string]interface{}{"name": "John Doe", "broken": "corrupted value", "city": "Moscow"},
+		PExpires: map[string]int64{},
+	}
+	writeSnapshot(binaryDump, d, CompressionNone)
+	b := binaryDump.Bytes()
+	b[bytes.Index(b, []byte("corrupted"))] = 'C'
+	//JSON dump truncated in the middle of "broken" key
+	jsonDump := []byte(`{"data":{"name":"John Doe","city":"Moscow","broken":"corr`)
+	//Valid JSON dump with value of "broken" key which does not match its type
+	badValue := []byte(`{"data":{"name":"John Doe","city":"Moscow","broken":42},"types":{"broken":"set"},"pexpires":{}}`)
+
+	tests := []struct {
+		Dump          []byte
+		Recovery      string
+		ExpectedError bool
+		ExpectedKeys  int
+		Quarantined   bool
+		Skipped       int
+	}{
+		{b, RecoveryStrict, true, 0, false, 0},
+		{b, RecoveryQuarantine, false, 0, true, 0},
+		{b, RecoveryPartial, false, 2, false, 1},
+		{jsonDump, RecoveryStrict, true, 0, false, 0},
+		{jsonDump, RecoveryQuarantine, false, 0, true, 0},
+		{jsonDump, RecoveryPartial, false, 2, false, 0},
+		{badValue, RecoveryStrict, true, -1, false, 0},
+		{badValue, RecoveryQuarantine, false, 0, true, 0},
+		{badValue, RecoveryPartial, false, 2, false, 1},
+	}
+	for i, test := range tests {
+		dumpFile := filepath.Join(dir, fmt.Sprintf("dump%d", i))
+		ioutil.WriteFile(dumpFile, test.Dump, 0644)
+		s := newStore(Options{DumpFile: dumpFile, Recovery: test.Recovery})
+		err := s.load()
+		if err == nil && test.ExpectedError || err != nil && !test.ExpectedError {
+			t.Errorf("Expected error for %s: %t, received: %v", test.Recovery, test.ExpectedError, err)
+		}
+		report := s.LoadReport()
+		if test.ExpectedKeys >= 0 && (report.Keys != test.ExpectedKeys || s.keysCount() != test.ExpectedKeys) {
+			t.Errorf("Wrong number of loaded keys for %s: got %d, expected %d", test.Recovery, report.Keys, test.ExpectedKeys)
+		}
+		if report.Skipped != test.Skipped {
+			t.Errorf("Wrong number of skipped records for %s: got %d, expected %d", test.Recovery, report.Skipped, test.Skipped)
+		}
+		if test.Quarantined {
+			if _, err := os.Stat(dumpFile); !os.IsNotExist(err) || report.Quarantined == "" {
+				t.Errorf("Corrupted dump must being moved aside, report: %s", report.String())
+			}
+		}
+	}
+
+	if err := newStore(Options{DumpFile: filepath.Join(dir, "missing")}).load(); err != nil {
+		t.Errorf("Missing dump must not being an error: %v", err)
+	}
+}
+
+func TestCloseSavesDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{DumpFile: filepath.Join(dir, "dump"), DumpInterval: 3600}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("name", "John Doe")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Second close must do nothing: got %v", err)
+	}
+
+	l, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if v, _ := l.Get("name"); v != "John Doe" {
+		t.Errorf("Wrong value after restart: got %v, expected %v", v, "John Doe")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	tests := []struct {
+		Policy   string
+		Evicted  string
+		Rejected bool
+	}{
+		{PolicyNoEviction, "", true},
+		{PolicyAllKeysLRU, "b", false},
+		{PolicyAllKeysLFU, "c", false},
+		{PolicyVolatileLRU, "d", false},
+		{PolicyVolatileTTL, "a", false},
+	}
+	for _, test := range tests {
+		s := newStore(Options{Shards: 1, MaxKeys: 4, EvictionPolicy: test.Policy})
+		currentTime := time.Unix(1000, 0)
+		s.now = func() time.Time { return currentTime }
+		s.SetWithExpires("a", "1", 1000)
+		s.Set("b", "2")
+		s.Set("c", "3")
+		s.SetWithExpires("d", "4", 5000)
+		for i := 0; i < 20; i++ {
+			currentTime = currentTime.Add(time.Millisecond)
+			s.Get("a")
+			s.Get("b")
+			s.Get("d")
+		}
+		currentTime = currentTime.Add(time.Millisecond)
+		s.Get("d")
+		currentTime = currentTime.Add(time.Millisecond)
+		s.Get("a")
+		s.Get("c")
+
+		if err := s.Set("a", "updated"); err != nil {
+			t.Errorf("Overwriting existing key must not require eviction with %s, received: %v", test.Policy, err)
+		}
+		err := s.Set("e", "5")
+		if test.Rejected {
+			if err != ErrOutOfMemory {
+				t.Errorf("Expected ErrOutOfMemory with %s, received: %v", test.Policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error with %s: %v", test.Policy, err)
+		}
+		if _, ok := s.Get(test.Evicted); ok {
+			t.Errorf("Key %s must being evicted with %s", test.Evicted, test.Policy)
+		}
+		if stats := s.Stats(); stats["keys"] != 4 || stats["evictedKeys"] != int64(1) {
+			t.Errorf("Wrong stats with %s: %v", test.Policy, stats)
+		}
+	}
+
+	s := newStore(Options{MaxKeys: 1, EvictionPolicy: PolicyVolatileTTL})
+	s.Set("persistent", "value")
+	if err := s.Set("other", "value"); err != ErrOutOfMemory {
+		t.Errorf("Expected ErrOutOfMemory without volatile keys, received: %v", err)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	s := newStore(Options{MaxMemory: 10000, EvictionPolicy: PolicyAllKeysLRU})
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 100; i++ {
+		if err := s.Set(strconv.Itoa(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := s.Stats()
+	if used := stats["usedMemory"].(int64); used > 10000 || used < 5000 {
+		t.Errorf("Wrong used memory: got %d, expected about %d", used, 10000)
+	}
+	if stats["evictedKeys"].(int64) == 0 {
+		t.Errorf("Keys must being evicted")
+	}
+	keys := stats["keys"]
+	if err := s.Set("huge", strings.Repeat("x", 20000)); err != ErrOutOfMemory {
+		t.Errorf("Value larger than max memory must being rejected: got %v", err)
+	}
+	if _, err := s.RPush("queue", strings.Repeat("x", 20000)); err != ErrOutOfMemory {
+		t.Errorf("List larger than max memory must being rejected: got %v", err)
+	}
+	if stats := s.Stats(); stats["keys"] != keys {
+		t.Errorf("Keys must not being evicted for rejected value: got %v, expected %v", stats["keys"], keys)
+	}
+	for i := 0; i < 100; i++ {
+		s.Remove(strconv.Itoa(i))
+	}
+	if stats := s.Stats(); stats["usedMemory"] != int64(0) || stats["keys"] != 0 {
+		t.Errorf("Memory must being released after removing keys: %v", stats)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+	s.Set("list", []interface{}{"1"})
+	s.Set("max", strconv.FormatInt(math.MaxInt64, 10))
+
+	tests := []struct {
+		Key           string
+		Delta         int64
+		Expected      int64
+		ExpectedError error
+	}{
+		{"counter", 1, 1, nil},
+		{"counter", 10, 11, nil},
+		{"counter", -20, -9, nil},
+		{"name", 1, 0, ErrNotInteger},
+		{"list", 1, 0, ErrWrongType},
+		{"max", 1, 0, ErrOverflow},
+	}
+	for _, test := range tests {
+		v, err := s.IncrBy(test.Key, test.Delta, 0)
+		if err != test.ExpectedError || v != test.Expected {
+			t.Errorf("Wrong result of incrBy %s %d: got %d (%v), expected %d (%v)", test.Key, test.Delta, v, err, test.Expected, test.ExpectedError)
+		}
+	}
+	if v, _ := s.Get("counter"); v != "-9" {
+		t.Errorf("Counter must being stored as string: got %v", v)
+	}
+
+	if v, err := s.IncrByFloat("counter", 1.5, 0); err != nil || v != -7.5 {
+		t.Errorf("Wrong result of incrByFloat: got %v (%v), expected %v", v, err, -7.5)
+	}
+	if _, err := s.IncrBy("counter", 1, 0); err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger for float value, received: %v", err)
+	}
+
+	s.IncrBy("window", 1, 1000)
+	s.IncrBy("window", 1, 5000)
+	if expires, _ := s.GetPExpires("window"); expires > 1000 {
+		t.Errorf("Expiration time must being set only when counter is created, got %d", expires)
+	}
+}
+
+func TestType(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+	s.Set("hobbies", []interface{}{"web", "sport"})
+	s.Set("langs", map[string]interface{}{"programming": "Golang"})
+	s.SAdd("tags", "go")
+	s.ZAdd("leaders", ZMember{"john", 10})
+	s.Incr("visits")
+	s.Set("blob", []byte("data"))
+
+	tests := []struct {
+		Key      string
+		Expected string
+	}{
+		{"name", TypeString},
+		{"hobbies", TypeList},
+		{"langs", TypeHash},
+		{"tags", TypeSet},
+		{"leaders", TypeZSet},
+		{"visits", TypeCounter},
+		{"blob", TypeBytes},
+	}
+	for _, test := range tests {
+		if typ, ok := s.Type(test.Key); !ok || typ != test.Expected {
+			t.Errorf("Wrong type of %s: got %s, expected %s", test.Key, typ, test.Expected)
+		}
+	}
+	if _, ok := s.Type("missing"); ok {
+		t.Errorf("Type of missing key must not being returned")
+	}
+
+	if _, err := s.LPush("name", "x"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType for push into string, received: %v", err)
+	}
+	if _, err := s.HSet("hobbies", map[string]string{"a": "b"}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType for hset into list, received: %v", err)
+	}
+	if _, err := s.Incr("blob"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType for incr of bytes, received: %v", err)
+	}
+	if _, err := s.Incr("tags"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType for incr of set, received: %v", err)
+	}
+	if v, ok, err := s.LIndex("hobbies", -1); err != nil || !ok || v != "sport" {
+		t.Errorf("Wrong result of lindex: got %v %v (%v)", v, ok, err)
+	}
+	if _, ok, _ := s.LIndex("hobbies", 2); ok {
+		t.Errorf("Item out of range must not being returned")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s := newStore(Options{})
+	v1, err := s.SetIf("name", "John Doe", 0, Condition{Exists: IfNotExists})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetIf("name", "Jane Doe", 0, Condition{Exists: IfNotExists}); err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for existing key, received: %v", err)
+	}
+	if _, err := s.SetIf("missing", "value", 0, Condition{Exists: IfExists}); err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for missing key, received: %v", err)
+	}
+	v2, err := s.SetIf("name", "Jane Doe", 0, Condition{Version: v1})
+	if err != nil || v2 <= v1 {
+		t.Errorf("Wrong result of set with version: got %d (%v), previous version %d", v2, err, v1)
+	}
+	if _, err := s.SetIf("name", "John Doe", 0, Condition{Version: v1}); err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for stale version, received: %v", err)
+	}
+	if _, err := s.SetIf("name", "John Doe", 0, Condition{NotVersion: v2}); err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for current version, received: %v", err)
+	}
+	if e, _ := s.GetEntry("name"); e.Value != "Jane Doe" || e.Version != v2 || e.Type != TypeString {
+		t.Errorf("Wrong entry: got %v", e)
+	}
+
+	s.SAdd("tags", "go")
+	before, _ := s.Version("tags")
+	s.SAdd("tags", "go")
+	if v, _ := s.Version("tags"); v != before {
+		t.Errorf("Version must not change if set is not modified")
+	}
+	s.SAdd("tags", "cache")
+	if v, _ := s.Version("tags"); v <= before {
+		t.Errorf("Version must grow when set is modified in place")
+	}
+
+	if _, err := s.RemoveIf("name", Condition{Version: v1}); err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for remove with stale version, received: %v", err)
+	}
+	if ok, err := s.RemoveIf("name", Condition{Version: v2}); !ok || err != nil {
+		t.Errorf("Key must being removed with current version: got %v (%v)", ok, err)
+	}
+	v3, _ := s.SetIf("name", "John Doe", 0, Condition{})
+	if v3 <= v2 {
+		t.Errorf("Version of created again key must grow: got %d, previous %d", v3, v2)
+	}
+}
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{AOFFile: filepath.Join(dir, "kvstore.aof"), AOFFsync: FsyncAlways}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("from", "10")
+	s.Set("name", "John Doe")
+	version, _ := s.Version("from")
+
+	results, err := s.Exec([]Op{
+		{Op: OpIncr, Key: "from", Delta: -3, Version: version},
+		{Op: OpIncr, Key: "to", Delta: 3, Expires: 60000},
+		{Op: OpSet, Key: "log", Value: "moved"},
+		{Op: OpRemove, Key: "name"},
+		{Op: OpRemove, Key: "missing"},
+		{Op: OpExpire, Key: "log", Expires: 60000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []OpResult{{OK: true, Value: int64(7)}, {OK: true, Value: int64(3)}, {OK: true}, {OK: true}, {OK: false}, {OK: true}}
+	for i, result := range results {
+		if result.OK != expected[i].OK || result.Value != expected[i].Value {
+			t.Errorf("Wrong result of operation %d: got %v, expected %v", i, result, expected[i])
+		}
+	}
+	if results[0].Version == 0 || results[2].Version == 0 {
+		t.Errorf("Version of written keys must being returned")
+	}
+
+	_, err = s.Exec([]Op{
+		{Op: OpSet, Key: "from", Value: "0"},
+		{Op: OpIncr, Key: "log", Delta: 1},
+	})
+	if e, ok := err.(*TxError); !ok || e.Index != 1 || e.Err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger in operation 1, received: %v", err)
+	}
+	_, err = s.Exec([]Op{
+		{Op: OpRemove, Key: "to"},
+		{Op: OpSet, Key: "from", Value: "0", Version: version},
+	})
+	if e, ok := err.(*TxError); !ok || e.Err != ErrConditionFailed {
+		t.Errorf("Expected ErrConditionFailed for stale version, received: %v", err)
+	}
+
+	checkLoaded := func(l *Store) {
+		for key, expected := range map[string]interface{}{"from": "7", "to": "3", "log": "moved"} {
+			if v, _ := l.Get(key); v != expected {
+				t.Errorf("Wrong value of %s: got %v, expected %v", key, v, expected)
+			}
+		}
+		if _, ok := l.Get("name"); ok {
+			t.Errorf("Removed key must not exist")
+		}
+		if _, ok := l.GetPExpires("log"); !ok {
+			t.Errorf("Expiration time must being set by transaction")
+		}
+	}
+	checkLoaded(s)
+	l, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(l)
+}
+
+func TestRename(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	currentTime := time.Unix(1000, 0)
+	s.now = func() time.Time { return currentTime }
+	s.SetWithExpires("session", "data", 60000)
+	s.SetWithExpires("target", "old", 30000)
+	s.SAdd("tags", "go", "cache")
+	s.Set("name", "John Doe")
+
+	if err := s.Rename("session", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("session"); ok {
+		t.Errorf("Renamed key must not exist")
+	}
+	if expires, _ := s.GetPExpires("moved"); expires != 60000 {
+		t.Errorf("Wrong expiration time of renamed key: got %d, expected %d", expires, 60000)
+	}
+	if err := s.Rename("name", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("target"); v != "John Doe" {
+		t.Errorf("Wrong value of renamed key: got %v, expected %v", v, "John Doe")
+	}
+	if _, ok := s.GetExpires("target"); ok {
+		t.Errorf("Expiration time of overwritten key must being removed")
+	}
+	if err := s.Rename("missing", "other"); err != ErrNoSuchKey {
+		t.Errorf("Wrong error of missing key: got %v, expected %v", err, ErrNoSuchKey)
+	}
+	if err := s.Rename("target", "target"); err != nil {
+		t.Errorf("Rename of key into itself must succeed: got %v", err)
+	}
+
+	if ok, _ := s.RenameNX("moved", "target"); ok {
+		t.Errorf("Key must not being renamed into existing key")
+	}
+	if ok, _ := s.RenameNX("moved", "session"); !ok {
+		t.Errorf("Key must being renamed into missing key")
+	}
+
+	if ok, err := s.Copy("tags", "tags-copy", false); !ok || err != nil {
+		t.Fatalf("Key must being copied: got %t %v", ok, err)
+	}
+	s.SAdd("tags-copy", "db")
+	if v, _ := s.SMembers("tags"); !reflect.DeepEqual(v, Set{"cache", "go"}) {
+		t.Errorf("Copy must not share value with source: got %v", v)
+	}
+	if typ, _ := s.Type("tags-copy"); typ != TypeSet {
+		t.Errorf("Wrong type of copy: got %s, expected %s", typ, TypeSet)
+	}
+	if ok, _ := s.Copy("session", "tags", false); ok {
+		t.Errorf("Existing key must not being replaced without replace")
+	}
+	if ok, _ := s.Copy("session", "tags", true); !ok {
+		t.Errorf("Existing key must being replaced with replace")
+	}
+	if expires, _ := s.GetPExpires("tags"); expires != 60000 {
+		t.Errorf("Wrong expiration time of copy: got %d, expected %d", expires, 60000)
+	}
+	if _, err := s.Copy("tags", "tags", true); err != ErrSameKey {
+		t.Errorf("Wrong error of copy into itself: got %v, expected %v", err, ErrSameKey)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newStore(Options{Shards: 4, MaxKeys: 3, EvictionPolicy: PolicyVolatileTTL})
+	currentTime := time.Unix(1000, 0)
+	s.now = func() time.Time { return currentTime }
+	sub, err := s.Subscribe("user:*", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := s.Subscribe("", 10)
+
+	s.Set("user:1", "John Doe")
+	s.Set("other", "value")
+	s.SAdd("user:tags", "go")
+	s.SAdd("user:tags", "cache")
+	s.Remove("user:1")
+	s.SetWithExpires("user:session", "data", 1000)
+	currentTime = currentTime.Add(time.Second)
+	for _, sh := range s.shards {
+		sh.removeExpired(unixMilli(currentTime))
+	}
+	s.SetWithExpires("user:lock", "owner", 5000)
+	s.Set("user:2", "Jane Doe")
+
+	expected := []Event{
+		{EventSet, "user:1", 0},
+		{EventSet, "user:tags", 0},
+		{EventSet, "user:tags", 0},
+		{EventRemove, "user:1", 0},
+		{EventSet, "user:session", 0},
+		{EventExpire, "user:session", 0},
+		{EventSet, "user:lock", 0},
+		{EventEvict, "user:lock", 0},
+		{EventSet, "user:2", 0},
+	}
+	for i, e := range expected {
+		select {
+		case got := <-sub.Events():
+			if got.Kind != e.Kind || got.Key != e.Key {
+				t.Errorf("Wrong event %d: got %s %s, expected %s %s", i, got.Kind, got.Key, e.Kind, e.Key)
+			}
+			if got.Kind == EventSet && got.Version == 0 {
+				t.Errorf("Version of set event %d must being set", i)
+			}
+		default:
+			t.Fatalf("Event %d is not delivered: expected %s %s", i, e.Kind, e.Key)
+		}
+	}
+	select {
+	case e := <-sub.Events():
+		t.Errorf("Unexpected event: %v", e)
+	default:
+	}
+	if n := len(all.Events()); n != len(expected)+1 {
+		t.Errorf("Wrong number of events without pattern: got %d, expected %d", n, len(expected)+1)
+	}
+
+	//subscriber which does not read events is dropped when buffer is full
+	slow, _ := s.Subscribe("", 2)
+	for i := 0; i < 3; i++ {
+		s.Set("user:2", "value")
+	}
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	if n != 2 || !slow.Dropped() {
+		t.Errorf("Slow subscriber must being dropped after %d events: got %d events, dropped %t", 2, n, slow.Dropped())
+	}
+
+	sub.Close()
+	for i := 0; i < 3; i++ {
+		<-sub.Events()
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Errorf("Events channel must being closed after buffered events")
+	}
+	if sub.Dropped() {
+		t.Errorf("Closed subscription must not being dropped")
+	}
+	if _, err := s.Subscribe("user:[", 10); err == nil {
+		t.Errorf("Malformed pattern must return error")
+	}
+}
+
+func TestWaitVersion(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	s.Set("key", "v1")
+	version, _ := s.Version("key")
+
+	e, ok := s.WaitVersion(context.Background(), "key", version-1)
+	if !ok || e.Value != "v1" || e.Version != version {
+		t.Errorf("Newer version must being returned at once: got %v %t", e, ok)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	e, ok = s.WaitVersion(ctx, "key", version)
+	cancel()
+	if !ok || e.Version != version {
+		t.Errorf("Current value must being returned on timeout: got %v %t", e, ok)
+	}
+	if n := len(s.shard("key").waiters); n != 0 {
+		t.Errorf("Waiter must being removed on timeout: got %d waiters", n)
+	}
+
+	done := make(chan Entry)
+	go func() {
+		e, _ := s.WaitVersion(context.Background(), "key", version)
+		done <- e
+	}()
+	go func() {
+		e, _ := s.WaitVersion(context.Background(), "new", 0)
+		done <- e
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Set("other", "value")
+	s.Set("key", "v2")
+	s.Set("new", "value")
+	got := []interface{}{(<-done).Value, (<-done).Value}
+	sort.Slice(got, func(i, j int) bool { return got[i].(string) < got[j].(string) })
+	if !reflect.DeepEqual(got, []interface{}{"v2", "value"}) {
+		t.Errorf("Waiters must being woken by change of their keys: got %v", got)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Remove("key")
+	}()
+	version, _ = s.Version("key")
+	if e, ok := s.WaitVersion(ctx, "key", version); ok {
+		t.Errorf("Removed key must not being returned: got %v", e)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	s := newStore(Options{Shards: 4})
+	s.RPush("list", "a", "b")
+	if v, ok, err := s.BLPop(context.Background(), "list"); err != nil || !ok || v != "a" {
+		t.Errorf("Existing item must being popped at once: got %v %t %v", v, ok, err)
+	}
+	if v, ok, err := s.BRPop(context.Background(), "list"); err != nil || !ok || v != "b" {
+		t.Errorf("Existing item must being popped at once: got %v %t %v", v, ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	v, ok, err := s.BLPop(ctx, "list")
+	cancel()
+	if err != nil || ok {
+		t.Errorf("Pop from missing list must time out: got %v %t %v", v, ok, err)
+	}
+
+	const consumers = 4
+	results := make(chan interface{}, consumers)
+	for i := 0; i < consumers; i++ {
+		go func() {
+			v, _, _ := s.BLPop(context.Background(), "list")
+			results <- v
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < consumers; i++ {
+		s.RPush("list", strconv.Itoa(i))
+	}
+	got := []string{}
+	for i := 0; i < consumers; i++ {
+		got = append(got, (<-results).(string))
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"0", "1", "2", "3"}) {
+		t.Errorf("Every pushed item must being popped by one consumer: got %v", got)
+	}
+	if n, _ := s.LLen("list"); n != 0 {
+		t.Errorf("List must being empty: got %d items", n)
+	}
+
+	s.Set("string", "value")
+	if _, _, err := s.BLPop(context.Background(), "string"); err != ErrWrongType {
+		t.Errorf("Pop from string must return ErrWrongType: got %v", err)
+	}
+
+	//Waiters are finished on shutdown
+	popped := make(chan bool, 1)
+	go func() {
+		_, ok, _ := s.BRPop(context.Background(), "list")
+		popped <- ok
+	}()
+	waited := make(chan Entry, 1)
+	go func() {
+		e, _ := s.WaitVersion(context.Background(), "string", math.MaxUint64)
+		waited <- e
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.CloseWaiters()
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Errorf("Pop finished by CloseWaiters must not return item")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Blocking pop must being finished by CloseWaiters")
+	}
+	select {
+	case e := <-waited:
+		if e.Value != "value" {
+			t.Errorf("Wait finished by CloseWaiters must return current value: got %v", e.Value)
 		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait for version must being finished by CloseWaiters")
 	}
+	if _, ok, _ := s.BLPop(context.Background(), "list"); ok {
+		t.Errorf("Pop after CloseWaiters must not block and return item")
+	}
+}
+
+func TestIncrParallel(t *testing.T) {
+	s := newStore(Options{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Incr("views")
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := s.Get("views"); v != "1000" {
+		t.Errorf("Lost updates: got %v, expected %v", v, "1000")
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+
+	if n, err := s.RPush("queue", "b", "c"); err != nil || n != 2 {
+		t.Errorf("Wrong result of rpush: got %d (%v), expected %d", n, err, 2)
+	}
+	if n, err := s.LPush("queue", "a", "z"); err != nil || n != 4 {
+		t.Errorf("Wrong result of lpush: got %d (%v), expected %d", n, err, 4)
+	}
+	l, _ := s.LRange("queue", 0, -1)
+	if !reflect.DeepEqual(l, []interface{}{"z", "a", "b", "c"}) {
+		t.Errorf("Wrong list: got %v", l)
+	}
+	snapshot, _ := s.Get("queue")
+
+	rangeTests := []struct {
+		Start    int
+		Stop     int
+		Expected []interface{}
+	}{
+		{1, 2, []interface{}{"a", "b"}},
+		{-2, -1, []interface{}{"b", "c"}},
+		{-100, 100, []interface{}{"z", "a", "b", "c"}},
+		{3, 1, []interface{}{}},
+	}
+	for _, test := range rangeTests {
+		if l, _ := s.LRange("queue", test.Start, test.Stop); !reflect.DeepEqual(l, test.Expected) {
+			t.Errorf("Wrong range %d %d: got %v, expected %v", test.Start, test.Stop, l, test.Expected)
+		}
+	}
+
+	if v, ok, _ := s.LPop("queue"); !ok || v != "z" {
+		t.Errorf("Wrong result of lpop: got %v", v)
+	}
+	if v, ok, _ := s.RPop("queue"); !ok || v != "c" {
+		t.Errorf("Wrong result of rpop: got %v", v)
+	}
+	s.RPush("queue", "d", "a")
+	if err := s.LSet("queue", -1, "e"); err != nil {
+		t.Error(err)
+	}
+	if err := s.LSet("queue", 10, "e"); err != ErrIndexOutOfRange {
+		t.Errorf("Expected ErrIndexOutOfRange, received: %v", err)
+	}
+	if n, _ := s.LInsert("queue", true, "d", "a"); n != 5 {
+		t.Errorf("Wrong result of linsert: got %d, expected %d", n, 5)
+	}
+	if n, _ := s.LRem("queue", -1, "a"); n != 1 {
+		t.Errorf("Wrong result of lrem: got %d, expected %d", n, 1)
+	}
+	if l, _ := s.LRange("queue", 0, -1); !reflect.DeepEqual(l, []interface{}{"a", "b", "d", "e"}) {
+		t.Errorf("Wrong list: got %v", l)
+	}
+	if !reflect.DeepEqual(snapshot, []interface{}{"z", "a", "b", "c"}) {
+		t.Errorf("List returned by Get must not being modified: got %v", snapshot)
+	}
+
+	s.LTrim("queue", 1, 2)
+	if n, _ := s.LLen("queue"); n != 2 {
+		t.Errorf("Wrong length after ltrim: got %d, expected %d", n, 2)
+	}
+	s.LTrim("queue", 5, 10)
+	if _, ok := s.Get("queue"); ok {
+		t.Errorf("Empty list must being removed")
+	}
+	if _, ok, err := s.LPop("queue"); ok || err != nil {
+		t.Errorf("Pop from missing list must return nothing: got %v (%v)", ok, err)
+	}
+	if _, err := s.RPush("name", "a"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, received: %v", err)
+	}
+
+	//list is used as queue: ring buffer grows, wraps around and shrinks, size is kept by deltas
+	for i := 0; i < 1000; i++ {
+		s.LPush("jobs", strconv.Itoa(i))
+		if i%3 == 0 {
+			s.RPop("jobs")
+		}
+	}
+	for i := 0; i < 600; i++ {
+		s.RPop("jobs")
+	}
+	s.LSet("jobs", 0, "longer value")
+	if l, _ := s.LRange("jobs", 0, 1); !reflect.DeepEqual(l, []interface{}{"longer value", "998"}) {
+		t.Errorf("Wrong list after pushes and pops: got %v", l)
+	}
+	if v, ok, _ := s.LIndex("jobs", -1); !ok || v != "934" {
+		t.Errorf("Wrong last item: got %v, expected %v", v, "934")
+	}
+	sh := s.shard("jobs")
+	if it := sh.data["jobs"]; it.size != itemSize("jobs", it.value) {
+		t.Errorf("Wrong size of list: got %d, expected %d", it.size, itemSize("jobs", it.value))
+	}
+}
+
+func TestHash(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+	user := map[string]interface{}{"name": "John"}
+	s.Set("user", user)
+	snapshot, _ := s.Get("user")
+
+	if n, err := s.HSet("user", map[string]string{"name": "Jane", "city": "Moscow"}); err != nil || n != 1 {
+		t.Errorf("Wrong result of hset: got %d (%v), expected %d", n, err, 1)
+	}
+	if v, ok, _ := s.HGet("user", "name"); !ok || v != "Jane" {
+		t.Errorf("Wrong result of hget: got %v", v)
+	}
+	if v, err := s.HIncrBy("user", "visits", 2); err != nil || v != 2 {
+		t.Errorf("Wrong result of hincrby: got %d (%v), expected %d", v, err, 2)
+	}
+	if _, err := s.HIncrBy("user", "name", 1); err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger, received: %v", err)
+	}
+	if keys, _ := s.HKeys("user"); !reflect.DeepEqual(keys, []string{"city", "name", "visits"}) {
+		t.Errorf("Wrong result of hkeys: got %v", keys)
+	}
+	if n, _ := s.HDel("user", "city", "missing"); n != 1 {
+		t.Errorf("Wrong result of hdel: got %d, expected %d", n, 1)
+	}
+	if ok, _ := s.HExists("user", "city"); ok {
+		t.Errorf("Removed field must not exist")
+	}
+	if h, _ := s.HGetAll("user"); !reflect.DeepEqual(h, map[string]interface{}{"name": "Jane", "visits": "2"}) {
+		t.Errorf("Wrong result of hgetall: got %v", h)
+	}
+	if !reflect.DeepEqual(snapshot, map[string]interface{}{"name": "John"}) {
+		t.Errorf("Hash returned by Get must not being modified: got %v", snapshot)
+	}
+	if !reflect.DeepEqual(user, map[string]interface{}{"name": "John"}) {
+		t.Errorf("Hash passed to Set must not being modified: got %v", user)
+	}
+	sh := s.shard("user")
+	if it := sh.data["user"]; it.size != itemSize("user", it.value) {
+		t.Errorf("Wrong size of hash: got %d, expected %d", it.size, itemSize("user", it.value))
+	}
+
+	s.HDel("user", "name", "visits")
+	if n, _ := s.HLen("user"); n != 0 {
+		t.Errorf("Wrong result of hlen: got %d, expected %d", n, 0)
+	}
+	if _, ok := s.Get("user"); ok {
+		t.Errorf("Empty hash must being removed")
+	}
+	if _, err := s.HSet("name", map[string]string{"a": "b"}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, received: %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := newStore(Options{})
+	s.Set("name", "John Doe")
+
+	if n, err := s.SAdd("a", "x", "y", "z", "x"); err != nil || n != 3 {
+		t.Errorf("Wrong result of sadd: got %d (%v), expected %d", n, err, 3)
+	}
+	s.SAdd("b", "y", "z", "w")
+	if ok, _ := s.SIsMember("a", "x"); !ok {
+		t.Errorf("Member must being in set")
+	}
+	if n, _ := s.SRem("a", "z", "missing"); n != 1 {
+		t.Errorf("Wrong result of srem: got %d, expected %d", n, 1)
+	}
+
+	tests := []struct {
+		Name     string
+		Fn       func(...string) (Set, error)
+		Keys     []string
+		Expected Set
+	}{
+		{"sinter", s.SInter, []string{"a", "b"}, Set{"y"}},
+		{"sinter", s.SInter, []string{"a", "missing"}, Set{}},
+		{"sunion", s.SUnion, []string{"a", "b"}, Set{"w", "x", "y", "z"}},
+		{"sdiff", s.SDiff, []string{"b", "a"}, Set{"w", "z"}},
+	}
+	for _, test := range tests {
+		if v, err := test.Fn(test.Keys...); err != nil || !reflect.DeepEqual(v, test.Expected) {
+			t.Errorf("Wrong result of %s %v: got %v (%v), expected %v", test.Name, test.Keys, v, err, test.Expected)
+		}
+	}
+	if _, err := s.SUnion("a", "name"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, received: %v", err)
+	}
+
+	v, _ := s.Get("a")
+	s.SAdd("a", "v")
+	if !reflect.DeepEqual(v, Set{"x", "y"}) {
+		t.Errorf("Set returned by Get must not being modified: got %v", v)
+	}
+	s.SRem("a", "v", "x", "y")
+	if _, ok := s.Get("a"); ok {
+		t.Errorf("Empty set must being removed")
+	}
+}
+
+func TestZSet(t *testing.T) {
+	s := newStore(Options{})
+	if n, err := s.ZAdd("scores", ZMember{"a", 3}, ZMember{"b", 1}, ZMember{"c", 2}); err != nil || n != 3 {
+		t.Errorf("Wrong result of zadd: got %d (%v), expected %d", n, err, 3)
+	}
+	if n, _ := s.ZAdd("scores", ZMember{"a", 0}, ZMember{"d", 5}); n != 1 {
+		t.Errorf("Wrong result of zadd: got %d, expected %d", n, 1)
+	}
+	if v, _ := s.ZIncrBy("scores", "b", 2.5); v != 3.5 {
+		t.Errorf("Wrong result of zincrby: got %v, expected %v", v, 3.5)
+	}
+	if r, ok, _ := s.ZRank("scores", "b"); !ok || r != 2 {
+		t.Errorf("Wrong result of zrank: got %d, expected %d", r, 2)
+	}
+	if v, _ := s.ZRange("scores", 0, -1); !reflect.DeepEqual(v, ZSet{{"a", 0}, {"c", 2}, {"b", 3.5}, {"d", 5}}) {
+		t.Errorf("Wrong result of zrange: got %v", v)
+	}
+	if v, _ := s.ZRange("scores", -2, -1); !reflect.DeepEqual(v, ZSet{{"b", 3.5}, {"d", 5}}) {
+		t.Errorf("Wrong result of zrange: got %v", v)
+	}
+	if v, _ := s.ZRangeByScore("scores", 1, 3.5); !reflect.DeepEqual(v, ZSet{{"c", 2}, {"b", 3.5}}) {
+		t.Errorf("Wrong result of zrangebyscore: got %v", v)
+	}
+	if _, err := s.ZAdd("scores", ZMember{"e", math.Inf(1)}); err != ErrNotFiniteScore {
+		t.Errorf("Expected ErrNotFiniteScore, received: %v", err)
+	}
+	if n, _ := s.ZRem("scores", "a", "c", "missing"); n != 2 {
+		t.Errorf("Wrong result of zrem: got %d, expected %d", n, 2)
+	}
+	if n, _ := s.ZCard("scores"); n != 2 {
+		t.Errorf("Wrong result of zcard: got %d, expected %d", n, 2)
+	}
+
+	//failed increment must not create key nor send events
+	sub, _ := s.Subscribe("", 10)
+	defer sub.Close()
+	if _, err := s.ZIncrBy("missing", "a", math.NaN()); err != ErrNotFiniteScore {
+		t.Errorf("Expected ErrNotFiniteScore, received: %v", err)
+	}
+	if _, err := s.ZIncrBy("scores", "d", math.Inf(-1)); err != ErrNotFiniteScore {
+		t.Errorf("Expected ErrNotFiniteScore, received: %v", err)
+	}
+	if n, _ := s.ZCard("missing"); n != 0 {
+		t.Errorf("Failed zincrby must not create key: got %d members", n)
+	}
+	if v, _, _ := s.ZScore("scores", "d"); v != 5 {
+		t.Errorf("Failed zincrby must not change score: got %v, expected %v", v, 5)
+	}
+	select {
+	case e := <-sub.Events():
+		t.Errorf("Failed zincrby must not send events: got %v", e)
+	default:
+	}
+}
+
+func TestSkiplist(t *testing.T) {
+	sl := newSkiplist()
+	scores := map[string]float64{}
+	for i := 0; i < 1000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		if score, ok := scores[member]; ok {
+			sl.remove(score, member)
+		}
+		scores[member] = float64(rand.Intn(50))
+		sl.insert(scores[member], member)
+	}
+	members := ZSet{}
+	for member, score := range scores {
+		members = append(members, ZMember{member, score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Score < members[j].Score || members[i].Score == members[j].Score && members[i].Member < members[j].Member
+	})
+	if sl.length != len(members) {
+		t.Fatalf("Wrong length: got %d, expected %d", sl.length, len(members))
+	}
+	for i, m := range members {
+		if r := sl.rank(m.Score, m.Member); r != i+1 {
+			t.Errorf("Wrong rank of %v: got %d, expected %d", m, r, i+1)
+		}
+		if x := sl.byRank(i + 1); x == nil || x.member != m.Member {
+			t.Errorf("Wrong member with rank %d: got %v, expected %v", i+1, x, m)
+		}
+	}
+}
+
+//benchmarkKeys - fixed keyspace for benchmarks so map growth does not dominate results
+var benchmarkKeys = func() []string {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+//benchmarkSetParallel runs concurrent writes over store with given number of shards
+//Run with -cpu 1,2,4,8 to see how throughput scales with GOMAXPROCS
+func benchmarkSetParallel(b *testing.B, shards int) {
+	s := newStore(Options{Shards: shards})
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Set(benchmarkKeys[i%len(benchmarkKeys)], "value")
+			i++
+		}
+	})
+}
+
+//benchmarkMixedParallel runs concurrent reads and writes (1 write per 4 reads)
+func benchmarkMixedParallel(b *testing.B, shards int) {
+	s := newStore(Options{Shards: shards})
+	for _, key := range benchmarkKeys {
+		s.Set(key, "value")
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := benchmarkKeys[i%len(benchmarkKeys)]
+			if i%5 == 0 {
+				s.Set(key, "value")
+			} else {
+				s.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for _, shards := range []int{1, 8, DefaultShards, 128} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkSetParallel(b, shards)
+		})
+	}
+}
+
+func BenchmarkMixedParallel(b *testing.B) {
+	for _, shards := range []int{1, 8, DefaultShards, 128} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkMixedParallel(b, shards)
+		})
+	}
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	s := newStore(Options{})
+	for i := 0; i < 10000; i++ {
+		s.Set("key"+strconv.Itoa(i), []interface{}{"web", "sport", strconv.Itoa(i)})
+	}
+	d := s.snapshot()
+	b.Run("format=json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			json.NewEncoder(ioutil.Discard).Encode(d)
+		}
+	})
+	b.Run("format=binary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			writeSnapshot(ioutil.Discard, d, CompressionNone)
+		}
+	})
+	b.Run("format=binary+gzip", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			writeSnapshot(ioutil.Discard, d, CompressionGzip)
+		}
+	})
 }
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+//Operations of transaction
+const (
+	OpSet    = "set"
+	OpRemove = "remove"
+	OpIncr   = "incr"
+	OpExpire = "expire"
+)
+
+//Errors of transactions
+var (
+	ErrUnknownOp      = errors.New("ERR unknown operation")
+	ErrInvalidExpires = errors.New("ERR invalid expire time")
+	ErrEmptyTx        = errors.New("ERR transaction has no operations")
+)
+
+//Op - operation of transaction
+//Set stores Value (with expiration time Expires in milliseconds if > 0), remove deletes key,
+//incr adds Delta to integer value (Expires is applied to created key only), expire sets expiration time Expires
+//Version (if > 0) must be equal to version of key before transaction
+type Op struct {
+	Op      string
+	Key     string
+	Value   interface{}
+	Delta   int64
+	Expires int64
+	Version uint64
+}
+
+//OpResult - result of operation of transaction
+//OK is false if remove or expire is applied to missing key, Value is result of incr,
+//Version is version of key after set or incr
+type OpResult struct {
+	OK      bool
+	Value   interface{}
+	Version uint64
+}
+
+//TxError - operation of transaction which failed, transaction is not applied
+type TxError struct {
+	Index int
+	Err   error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Err.Error())
+}
+
+//Exec applies operations in order all-or-nothing: shards of all keys are locked for writing,
+//preconditions are checked and operations are executed on staged copy of keys first,
+//so nothing is changed if any operation fails. Returns *TxError with failed operation
+func (s *Store) Exec(ops []Op) ([]OpResult, error) {
+	if len(ops) == 0 {
+		return nil, ErrEmptyTx
+	}
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+		var err error
+		switch op.Op {
+		case OpSet:
+			err = s.reserve(op.Key, op.Value)
+		case OpIncr:
+			err = s.reserve(op.Key, "")
+		}
+		if err != nil {
+			return nil, &TxError{Index: i, Err: err}
+		}
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+	currentTime := unixMilli(s.now())
+
+	//staged values of keys, nil item - key does not exist
+	staged := map[string]*item{}
+	for i, op := range ops {
+		it, _ := s.shard(op.Key).lookup(op.Key, currentTime)
+		if op.Version > 0 && (it == nil || it.version != op.Version) {
+			return nil, &TxError{Index: i, Err: ErrConditionFailed}
+		}
+		staged[op.Key] = it
+	}
+
+	results := make([]OpResult, len(ops))
+	records := make([]*aofRecord, len(ops))
+	values := make([]*item, len(ops))
+	for i, op := range ops {
+		it := staged[op.Key]
+		var rec *aofRecord
+		switch op.Op {
+		case OpSet:
+			if op.Expires < 0 {
+				return nil, &TxError{Index: i, Err: ErrInvalidExpires}
+			}
+			value, typ, err := storedValue("", op.Value)
+			if err != nil {
+				return nil, &TxError{Index: i, Err: err}
+			}
+			values[i] = &item{value: value, typ: typ}
+			rec = &aofRecord{Op: "set", Key: op.Key, Value: op.Value, Type: recordType(typ)}
+			if op.Expires > 0 {
+				rec.At = currentTime + op.Expires
+			}
+			results[i].OK = true
+		case OpRemove:
+			if it != nil {
+				rec = &aofRecord{Op: "remove", Key: op.Key}
+				results[i].OK = true
+			}
+		case OpIncr:
+			if op.Expires < 0 {
+				return nil, &TxError{Index: i, Err: ErrInvalidExpires}
+			}
+			current, err := incrInt(it, op.Delta)
+			if err != nil {
+				return nil, &TxError{Index: i, Err: err}
+			}
+			value := strconv.FormatInt(current, 10)
+			values[i] = &item{value: value, typ: TypeCounter}
+			rec = &aofRecord{Op: "set", Key: op.Key, Value: value, Type: TypeCounter}
+			if it == nil && op.Expires > 0 {
+				rec.At = currentTime + op.Expires
+			}
+			results[i].OK = true
+			results[i].Value = current
+		case OpExpire:
+			if op.Expires <= 0 {
+				return nil, &TxError{Index: i, Err: ErrInvalidExpires}
+			}
+			if it != nil {
+				rec = &aofRecord{Op: "expire", Key: op.Key, At: currentTime + op.Expires}
+				results[i].OK = true
+			}
+		default:
+			return nil, &TxError{Index: i, Err: ErrUnknownOp}
+		}
+		if rec != nil && rec.Op == "remove" {
+			staged[op.Key] = nil
+		} else if values[i] != nil {
+			staged[op.Key] = values[i]
+		}
+		records[i] = rec
+	}
+
+	tx := &aofRecord{Op: "tx", Time: currentTime}
+	for i, rec := range records {
+		if rec == nil {
+			continue
+		}
+		sh := s.shard(rec.Key)
+		switch rec.Op {
+		case "set":
+			it := sh.set(rec.Key, values[i].value, values[i].typ, currentTime)
+			if rec.At > 0 {
+				sh.setExpires(rec.Key, rec.At)
+			}
+			results[i].Version = it.version
+		case "remove":
+			sh.remove(rec.Key)
+		case "expire":
+			sh.setExpires(rec.Key, rec.At)
+		}
+		tx.Ops = append(tx.Ops, rec)
+	}
+	if len(tx.Ops) > 0 {
+		s.appendAOF(tx)
+	}
+	return results, nil
+}
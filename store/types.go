@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+//Value types, every key keeps type of its value
+const (
+	TypeString  = "string"
+	TypeList    = "list"
+	TypeHash    = "hash"
+	TypeSet     = "set"
+	TypeZSet    = "zset"
+	TypeCounter = "counter"
+	TypeBytes   = "bytes"
+)
+
+//typeOf returns type of value stored by generic Set
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case []interface{}, []string, *listValue:
+		return TypeList
+	case map[string]interface{}, map[string]string:
+		return TypeHash
+	case setValue, Set:
+		return TypeSet
+	case *zsetValue, ZSet:
+		return TypeZSet
+	case []byte:
+		return TypeBytes
+	default:
+		return TypeString
+	}
+}
+
+//validType returns true if typ is one of value types
+func validType(typ string) bool {
+	switch typ {
+	case TypeString, TypeList, TypeHash, TypeSet, TypeZSet, TypeCounter, TypeBytes:
+		return true
+	}
+	return false
+}
+
+//jsonType returns true if value of type is restored from JSON without type information
+func jsonType(typ string) bool {
+	return typ == TypeString || typ == TypeList || typ == TypeHash
+}
+
+//recordType returns type written into AOF record, empty for types restored from JSON by themselves
+func recordType(typ string) string {
+	if jsonType(typ) {
+		return ""
+	}
+	return typ
+}
+
+//storedValue converts value read from dump or AOF into stored representation and returns its type
+//typ is type recorded in dump or AOF, empty type is detected by value
+func storedValue(typ string, value interface{}) (interface{}, string, error) {
+	switch typ {
+	case "":
+		switch v := value.(type) {
+		case Set:
+			return newSetValue(v), TypeSet, nil
+		case ZSet:
+			return newZSetValue(v), TypeZSet, nil
+		case []interface{}:
+			return newListValue(v), TypeList, nil
+		case []string:
+			return newListValue(stringValues(v)), TypeList, nil
+		case map[string]interface{}, map[string]string:
+			return hashOf(v), TypeHash, nil
+		}
+		return value, typeOf(value), nil
+	case TypeString:
+		return value, typ, nil
+	case TypeHash:
+		switch value.(type) {
+		case map[string]interface{}, map[string]string:
+			return hashOf(value), typ, nil
+		}
+		return nil, "", fmt.Errorf("hash must be object, got %T", value)
+	case TypeList:
+		items, err := listOf(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return newListValue(items), typ, nil
+	case TypeCounter:
+		if _, ok := value.(string); !ok {
+			return nil, "", fmt.Errorf("counter must be string, got %T", value)
+		}
+		return value, typ, nil
+	case TypeBytes:
+		switch v := value.(type) {
+		case []byte:
+			return v, typ, nil
+		case string:
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid base64 bytes: %s", err.Error())
+			}
+			return b, typ, nil
+		}
+		return nil, "", fmt.Errorf("bytes must be base64 string, got %T", value)
+	case TypeSet:
+		members, err := stringsOf(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return newSetValue(members), typ, nil
+	case TypeZSet:
+		members, err := zmembersOf(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return newZSetValue(members), typ, nil
+	default:
+		return nil, "", fmt.Errorf("unknown type %s", typ)
+	}
+}
+
+//stringsOf converts decoded JSON array into strings
+func stringsOf(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case Set:
+		return v, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		members := make([]string, len(v))
+		for i, item := range v {
+			member, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("member must be string, got %T", item)
+			}
+			members[i] = member
+		}
+		return members, nil
+	default:
+		return nil, fmt.Errorf("members must be array, got %T", value)
+	}
+}
+
+//hashOf returns copy of map as stored hash, value must be map[string]interface{} or map[string]string
+func hashOf(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]string:
+		h := make(map[string]interface{}, len(v))
+		for field, item := range v {
+			h[field] = item
+		}
+		return h
+	default:
+		return cloneValue(v).(map[string]interface{})
+	}
+}
+
+//fieldsOf converts decoded JSON object of AOF record into fields of hash
+func fieldsOf(value interface{}) (map[string]string, error) {
+	switch v := value.(type) {
+	case map[string]string:
+		return v, nil
+	case map[string]interface{}:
+		fields := make(map[string]string, len(v))
+		for field, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("value of field must be string, got %T", item)
+			}
+			fields[field] = str
+		}
+		return fields, nil
+	default:
+		return nil, fmt.Errorf("fields must be object, got %T", value)
+	}
+}
+
+//zmembersOf converts decoded JSON array of objects with member and score into sorted set members
+func zmembersOf(value interface{}) (ZSet, error) {
+	switch v := value.(type) {
+	case ZSet:
+		return v, nil
+	case []interface{}:
+		members := make(ZSet, len(v))
+		for i, item := range v {
+			m, _ := item.(map[string]interface{})
+			member, ok := m["member"].(string)
+			score, scoreOk := m["score"].(float64)
+			if !ok || !scoreOk {
+				return nil, fmt.Errorf("sorted set member must be object with member and score, got %v", item)
+			}
+			members[i] = ZMember{Member: member, Score: score}
+		}
+		return members, nil
+	default:
+		return nil, fmt.Errorf("sorted set members must be array, got %T", value)
+	}
+}
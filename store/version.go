@@ -0,0 +1,152 @@
+package store
+
+import "errors"
+
+//ErrConditionFailed - precondition of conditional write does not match current state of key
+var ErrConditionFailed = errors.New("ERR precondition failed")
+
+//Values of Condition.Exists
+const (
+	IfAny = iota
+	IfExists
+	IfNotExists
+)
+
+//Condition - precondition of conditional write, zero value matches any state of key
+//Exists is one of IfAny, IfExists or IfNotExists
+//Version (if > 0) must be equal to current version of key, NotVersion (if > 0) must differ from it
+type Condition struct {
+	Exists     int
+	Version    uint64
+	NotVersion uint64
+}
+
+//match returns true if item (nil if key does not exist) satisfies condition
+func (c Condition) match(it *item) bool {
+	switch {
+	case c.Exists == IfExists && it == nil:
+		return false
+	case c.Exists == IfNotExists && it != nil:
+		return false
+	case c.Version > 0 && (it == nil || it.version != c.Version):
+		return false
+	case c.NotVersion > 0 && it != nil && it.version == c.NotVersion:
+		return false
+	}
+	return true
+}
+
+//Entry - value of key with its type and version
+type Entry struct {
+	Value   interface{}
+	Type    string
+	Version uint64
+}
+
+//Versions are assigned from counter of shard on every write of value, so version of key only grows
+//(also when key is removed and created again). Store with dump or AOF starts counters from time of start,
+//so versions keep growing after restart and stale ETags of clients do not match new values
+
+//startVersions starts version counters of shards from current time in milliseconds multiplied by 1024:
+//counter of previous run reaches start value of the next one only if shard assigned more than 1024 versions
+//per millisecond of its uptime. Versions stay below 2^53, so they are exact numbers in JSON clients
+func (s *Store) startVersions() {
+	start := uint64(unixMilli(s.now())) << 10
+	for _, sh := range s.shards {
+		sh.version = start
+	}
+}
+
+//nextVersion returns next version of value, shard must be locked for writing
+func (sh *shard) nextVersion() uint64 {
+	sh.version++
+	return sh.version
+}
+
+//modified assigns new version to item of key modified in place, shard must be locked for writing
+func (sh *shard) modified(key string, it *item) {
+	it.version = sh.nextVersion()
+	sh.notify(EventSet, key, it.version)
+}
+
+//GetEntry returns value by key together with its type and version
+func (s *Store) GetEntry(key string) (Entry, bool) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	return s.entry(sh, key, unixMilli(s.now()))
+}
+
+//entry returns value of key with its type and version, shard must be locked
+func (s *Store) entry(sh *shard, key string, currentTime int64) (Entry, bool) {
+	it, ok := sh.lookup(key, currentTime)
+	if !ok {
+		return Entry{}, false
+	}
+	s.touch(it, currentTime)
+	e := Entry{Value: it.value, Type: it.typ, Version: it.version}
+	switch it.value.(type) {
+	case map[string]interface{}, *listValue, setValue, *zsetValue:
+		e.Value = cloneValue(it.value)
+	}
+	return e, true
+}
+
+//Version returns current version of key, returns false if key does not exist
+func (s *Store) Version(key string) (uint64, bool) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	it, ok := sh.lookup(key, unixMilli(s.now()))
+	if !ok {
+		return 0, false
+	}
+	return it.version, true
+}
+
+//SetIf sets value if condition matches current state of key and returns new version
+//Expiration time in milliseconds is set if expires > 0, otherwise expiration time of existing key is kept
+//Returns ErrConditionFailed if condition does not match
+func (s *Store) SetIf(key string, value interface{}, expires int64, cond Condition) (uint64, error) {
+	stored, typ, err := storedValue("", value)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.reserve(key, value); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	it, _ := sh.lookup(key, currentTime)
+	if !cond.match(it) {
+		return 0, ErrConditionFailed
+	}
+	it = sh.set(key, stored, typ, currentTime)
+	rec := &aofRecord{Op: "set", Key: key, Value: value, Type: recordType(typ), Time: currentTime}
+	if expires > 0 {
+		sh.setExpires(key, currentTime+expires)
+		rec.At = currentTime + expires
+	}
+	s.appendAOF(rec)
+	return it.version, nil
+}
+
+//RemoveIf removes key if condition matches its current state, returns false if key does not exist
+//Returns ErrConditionFailed if condition does not match
+func (s *Store) RemoveIf(key string, cond Condition) (bool, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	it, _ := sh.lookup(key, unixMilli(s.now()))
+	if !cond.match(it) {
+		return false, ErrConditionFailed
+	}
+	if it == nil {
+		return false, nil
+	}
+	sh.remove(key)
+	s.appendAOF(&aofRecord{Op: "remove", Key: key})
+	return true, nil
+}
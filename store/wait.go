@@ -0,0 +1,114 @@
+package store
+
+import "context"
+
+//watch returns channel which is closed on the next change of key (set, remove, expire or evict)
+//Shard must be locked for writing
+func (sh *shard) watch(key string) chan struct{} {
+	ch := make(chan struct{})
+	if sh.waiters == nil {
+		sh.waiters = map[string][]chan struct{}{}
+	}
+	sh.waiters[key] = append(sh.waiters[key], ch)
+	return ch
+}
+
+//unwatch removes channel of waiter which stopped waiting before key is changed, shard must be locked for writing
+func (sh *shard) unwatch(key string, ch chan struct{}) {
+	waiters := sh.waiters[key]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(sh.waiters, key)
+		return
+	}
+	sh.waiters[key] = waiters
+}
+
+//wake closes channels of all waiters of key, shard must be locked for writing
+func (sh *shard) wake(key string) {
+	waiters, ok := sh.waiters[key]
+	if !ok {
+		return
+	}
+	for _, ch := range waiters {
+		close(ch)
+	}
+	delete(sh.waiters, key)
+}
+
+//CloseWaiters finishes blocking calls (WaitVersion, BLPop, BRPop) as if their ctx is done, calls made after it do not block
+//Server calls it on shutdown, so that long polling requests do not delay it
+func (s *Store) CloseWaiters() {
+	s.closeWaiters.Do(func() {
+		close(s.waitDone)
+	})
+}
+
+//WaitVersion blocks until version of key is greater than version or ctx is done and returns current value of key
+//Returns false if key does not exist when waiting is finished. Version 0 waits for key to exist
+func (s *Store) WaitVersion(ctx context.Context, key string, version uint64) (Entry, bool) {
+	sh := s.shard(key)
+	for {
+		sh.Lock()
+		e, ok := s.entry(sh, key, unixMilli(s.now()))
+		if ok && e.Version > version {
+			sh.Unlock()
+			return e, true
+		}
+		ch := sh.watch(key)
+		sh.Unlock()
+		select {
+		case <-ch:
+			continue
+		case <-ctx.Done():
+		case <-s.waitDone:
+		}
+		sh.Lock()
+		defer sh.Unlock()
+		sh.unwatch(key, ch)
+		return s.entry(sh, key, unixMilli(s.now()))
+	}
+}
+
+//bpop removes and returns first or last item of list, waits for item to be pushed if list does not exist
+//Returns false if ctx is done or waiters are closed before list has any item
+func (s *Store) bpop(ctx context.Context, key string, head bool) (interface{}, bool, error) {
+	sh := s.shard(key)
+	for {
+		sh.Lock()
+		value, ok, err := s.popLocked(sh, key, head, unixMilli(s.now()))
+		if ok || err != nil {
+			sh.Unlock()
+			return value, ok, err
+		}
+		ch := sh.watch(key)
+		sh.Unlock()
+		select {
+		case <-ch:
+			continue
+		case <-ctx.Done():
+		case <-s.waitDone:
+		}
+		sh.Lock()
+		sh.unwatch(key, ch)
+		sh.Unlock()
+		return nil, false, nil
+	}
+}
+
+//BLPop removes and returns first item of list, blocks until list has an item or ctx is done
+//Returns false if ctx is done first. Waiters of the same list are woken together and only one of them gets pushed item
+func (s *Store) BLPop(ctx context.Context, key string) (interface{}, bool, error) {
+	return s.bpop(ctx, key, true)
+}
+
+//BRPop removes and returns last item of list, blocks until list has an item or ctx is done
+//Returns false if ctx is done first
+func (s *Store) BRPop(ctx context.Context, key string) (interface{}, bool, error) {
+	return s.bpop(ctx, key, false)
+}
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"errors"
+	"math"
+)
+
+//ErrNotFiniteScore - score of sorted set member is NaN or infinity
+var ErrNotFiniteScore = errors.New("ERR score is not a finite number")
+
+//zsetValue - stored sorted set, modified in place under shard lock
+//Scores are kept in map for O(1) lookups and members are ordered by skiplist
+type zsetValue struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+//ZMember - member of sorted set with its score
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+//ZSet - members of sorted set ordered by score, returned by Get and written into dumps
+type ZSet []ZMember
+
+func newZSetValue(members ZSet) *zsetValue {
+	v := &zsetValue{
+		scores: make(map[string]float64, len(members)),
+		zsl:    newSkiplist(),
+	}
+	for _, m := range members {
+		v.add(m.Member, m.Score)
+	}
+	return v
+}
+
+//add sets score of member, returns true if member is added
+func (v *zsetValue) add(member string, score float64) bool {
+	current, ok := v.scores[member]
+	if ok {
+		if current == score {
+			return false
+		}
+		v.zsl.remove(current, member)
+	}
+	v.scores[member] = score
+	v.zsl.insert(score, member)
+	return !ok
+}
+
+//remove deletes member, returns false if there is no such member
+func (v *zsetValue) remove(member string) bool {
+	score, ok := v.scores[member]
+	if !ok {
+		return false
+	}
+	delete(v.scores, member)
+	v.zsl.remove(score, member)
+	return true
+}
+
+//members returns copy of all members ordered by score
+func (v *zsetValue) members() ZSet {
+	members := make(ZSet, 0, len(v.scores))
+	for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+	}
+	return members
+}
+
+//zmemberSize - approximate memory used by member of sorted set (map entry and skiplist node)
+func zmemberSize(member string) int64 {
+	return 2*int64(len(member)) + 96
+}
+
+//lookupZSet returns sorted set stored by key, shard must be locked
+//Returns ErrWrongType if key holds value of other type
+func (sh *shard) lookupZSet(key string, currentTime int64) (*zsetValue, *item, error) {
+	it, ok := sh.lookup(key, currentTime)
+	if !ok {
+		return nil, nil, nil
+	}
+	v, ok := it.value.(*zsetValue)
+	if it.typ != TypeZSet || !ok {
+		return nil, nil, ErrWrongType
+	}
+	return v, it, nil
+}
+
+//addZSetMembers sets scores of members, creates sorted set if key does not exist, returns number of added members
+//Shard must be locked for writing
+func (sh *shard) addZSetMembers(key string, members ZSet, currentTime int64) (int, error) {
+	v, it, err := sh.lookupZSet(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	created := it == nil
+	if created {
+		v = newZSetValue(nil)
+		it = sh.set(key, v, TypeZSet, currentTime)
+	}
+	added := 0
+	for _, m := range members {
+		if v.add(m.Member, m.Score) {
+			sh.resize(it, zmemberSize(m.Member))
+			added++
+		}
+	}
+	if len(v.scores) == 0 {
+		sh.remove(key)
+	} else if !created {
+		sh.modified(key, it)
+	}
+	return added, nil
+}
+
+//removeZSetMembers removes members from sorted set, returns removed members. Empty sorted set is removed
+//Shard must be locked for writing
+func (sh *shard) removeZSetMembers(key string, members []string, currentTime int64) ([]string, error) {
+	v, it, err := sh.lookupZSet(key, currentTime)
+	if err != nil || it == nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, member := range members {
+		if v.remove(member) {
+			sh.resize(it, -zmemberSize(member))
+			removed = append(removed, member)
+		}
+	}
+	if len(v.scores) == 0 {
+		sh.remove(key)
+	} else if len(removed) > 0 {
+		sh.modified(key, it)
+	}
+	return removed, nil
+}
+
+//ZAdd sets scores of members, creates sorted set if key does not exist. Returns number of added members
+func (s *Store) ZAdd(key string, members ...ZMember) (int, error) {
+	if len(members) == 0 {
+		return 0, ErrSetValueMissing
+	}
+	size := int64(0)
+	for _, m := range members {
+		if math.IsNaN(m.Score) || math.IsInf(m.Score, 0) {
+			return 0, ErrNotFiniteScore
+		}
+		size += zmemberSize(m.Member)
+	}
+	if err := s.grow(key, size); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	added, err := sh.addZSetMembers(key, members, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "zadd", Key: key, Value: ZSet(members), Time: currentTime})
+	return added, nil
+}
+
+//ZIncrBy adds delta to score of member (missing member is added with score 0), returns new score
+func (s *Store) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	if err := s.grow(key, zmemberSize(member)); err != nil {
+		return 0, err
+	}
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	v, _, err := sh.lookupZSet(key, currentTime)
+	if err != nil {
+		return 0, err
+	}
+	score := delta
+	if v != nil {
+		score += v.scores[member]
+	}
+	//score is checked before sorted set is created, so failed increment does not change anything
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, ErrNotFiniteScore
+	}
+	members := ZSet{{Member: member, Score: score}}
+	if _, err := sh.addZSetMembers(key, members, currentTime); err != nil {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "zadd", Key: key, Value: members, Time: currentTime})
+	return score, nil
+}
+
+//ZRem removes members from sorted set, returns number of removed members. Empty sorted set is removed
+func (s *Store) ZRem(key string, members ...string) (int, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	currentTime := unixMilli(s.now())
+	removed, err := sh.removeZSetMembers(key, members, currentTime)
+	if err != nil || len(removed) == 0 {
+		return 0, err
+	}
+	s.appendAOF(&aofRecord{Op: "zrem", Key: key, Value: removed, Time: currentTime})
+	return len(removed), nil
+}
+
+//ZScore returns score of member, returns false if key or member does not exist
+func (s *Store) ZScore(key string, member string) (float64, bool, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupZSet(key, unixMilli(s.now()))
+	if err != nil || v == nil {
+		return 0, false, err
+	}
+	score, ok := v.scores[member]
+	return score, ok, nil
+}
+
+//ZRank returns 0-based rank of member ordered by score, returns false if key or member does not exist
+func (s *Store) ZRank(key string, member string) (int, bool, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupZSet(key, unixMilli(s.now()))
+	if err != nil || v == nil {
+		return 0, false, err
+	}
+	score, ok := v.scores[member]
+	if !ok {
+		return 0, false, nil
+	}
+	return v.zsl.rank(score, member) - 1, true, nil
+}
+
+//ZCard returns number of members of sorted set, 0 if key does not exist
+func (s *Store) ZCard(key string) (int, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupZSet(key, unixMilli(s.now()))
+	if v == nil {
+		return 0, err
+	}
+	return len(v.scores), err
+}
+
+//ZRange returns members with ranks from start to stop inclusive, negative rank counts from the end
+func (s *Store) ZRange(key string, start int, stop int) (ZSet, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupZSet(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	result := ZSet{}
+	if v == nil {
+		return result, nil
+	}
+	start, stop, ok := listRange(start, stop, v.zsl.length)
+	if !ok {
+		return result, nil
+	}
+	for x := v.zsl.byRank(start + 1); x != nil && len(result) < stop-start; x = x.level[0].forward {
+		result = append(result, ZMember{Member: x.member, Score: x.score})
+	}
+	return result, nil
+}
+
+//ZRangeByScore returns members with scores from min to max inclusive
+func (s *Store) ZRangeByScore(key string, min float64, max float64) (ZSet, error) {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	v, _, err := sh.lookupZSet(key, unixMilli(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	result := ZSet{}
+	if v == nil {
+		return result, nil
+	}
+	for x := v.zsl.firstFrom(min); x != nil && x.score <= max; x = x.level[0].forward {
+		result = append(result, ZMember{Member: x.member, Score: x.score})
+	}
+	return result, nil
+}